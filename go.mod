@@ -2,10 +2,27 @@ module chewawi_web
 
 go 1.24.4
 
-        require (
-        github.com/go-chi/chi/v5 v5.2.2
-        github.com/golang-jwt/jwt/v5 v5.2.2
-        github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
-        github.com/joho/godotenv v1.5.1
-        github.com/lib/pq v1.10.9
-        )
+require (
+	github.com/go-chi/chi/v5 v5.2.2
+	github.com/golang-jwt/jwt/v5 v5.2.3
+	github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
+)
+
+require golang.org/x/crypto v0.45.0
+
+require github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+
+require golang.org/x/term v0.37.0
+
+require (
+	github.com/fxamacker/cbor/v2 v2.9.0 // indirect
+	github.com/go-webauthn/webauthn v0.13.4
+	github.com/go-webauthn/x v0.1.23 // indirect
+	github.com/google/go-tpm v0.9.5 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mitchellh/mapstructure v1.5.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+)
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+	"chewawi_web/src/utils"
+
+	"golang.org/x/term"
+)
+
+// runCommand runs a maintenance command given on the command line instead of the server
+func runCommand(args []string) error {
+	switch args[0] {
+	case "passwd":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s passwd <username>", os.Args[0])
+		}
+		return setPasswordCommand(args[1])
+	case "rerender":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s rerender", os.Args[0])
+		}
+		return rerenderCommand()
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// setPasswordCommand sets or rotates a user's password, creating them as an author if needed.
+// The password is read from standard input so it never shows up in the process list.
+func setPasswordCommand(username string) error {
+	// Get or create the user
+	user, err := models.GetUserByUsername(username)
+	if errors.Is(err, models.ErrNotFound) {
+		user, err = models.CreateUser(username, models.RoleAuthor)
+		if err != nil {
+			return fmt.Errorf("creating user: %w", err)
+		}
+		fmt.Printf("Created user %s\n", username)
+	} else if err != nil {
+		return fmt.Errorf("getting user: %w", err)
+	}
+
+	// Read the new password
+	password, err := readNewPassword(username)
+	if err != nil {
+		return err
+	}
+
+	// Hash and store it
+	hash, err := middleware.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := models.SetUserPassword(user.ID, hash); err != nil {
+		return fmt.Errorf("storing password: %w", err)
+	}
+
+	// Sign the user out everywhere in case the old password leaked
+	if err := models.DeleteUserSessions(user.ID, ""); err != nil {
+		return fmt.Errorf("revoking sessions: %w", err)
+	}
+
+	fmt.Printf("Password updated for %s\n", username)
+	return nil
+}
+
+// readNewPassword reads a new password from standard input. On a terminal it's
+// typed twice without being echoed, piped input is read as a single line.
+func readNewPassword(username string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		return strings.TrimRight(password, "\r\n"), nil
+	}
+
+	fmt.Printf("New password for %s: ", username)
+	password, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+
+	fmt.Print("Repeat the password: ")
+	confirmation, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	if string(password) != string(confirmation) {
+		return "", errors.New("the passwords don't match")
+	}
+
+	return string(password), nil
+}
+
+// rerenderCommand renders the HTML of every post again, such as after changing the
+// Markdown renderer. Posts left stale are otherwise rendered again when next viewed.
+func rerenderCommand() error {
+	count, err := models.RerenderPosts()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rendered %d posts with renderer %s\n", count, utils.MarkdownVersion)
+	return nil
+}
@@ -1,12 +1,16 @@
 package controllers
 
 import (
-	"html/template"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"chewawi_web/src/i18n"
 	"chewawi_web/src/middleware"
 	"chewawi_web/src/models"
 
@@ -15,50 +19,22 @@ import (
 
 // LoginHandler handles the GET /login route
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	// Prepare template data
-	data := TemplateData{
-		Title: "Login",
-	}
-
-	// First, render the content template
-	contentTmpl, err := template.ParseFiles("src/views/admin/login.html")
-	if err != nil {
-		log.Printf("Content template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
+	options := loginOptions(r)
 
-	// Execute content template to a buffer
-	var contentBuffer strings.Builder
-	err = contentTmpl.ExecuteTemplate(&contentBuffer, "login", data)
-	if err != nil {
-		log.Printf("Content template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Signed-in users go straight on
+	if _, ok := middleware.SessionFromContext(r.Context()); ok {
+		http.Redirect(w, r, options.Redirect(), http.StatusSeeOther)
 		return
 	}
 
-	// Add the rendered content to the data
-	data.Content = template.HTML(contentBuffer.String())
-
-	// Parse layout template
-	layoutTmpl, err := template.ParseFiles(
-		"src/views/layout.html",
-		"src/views/home/hero.html",
-		"src/views/home/footer.html",
-		"src/views/home/section.html",
-	)
-	if err != nil {
-		log.Printf("Layout template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	// Prepare template data
+	data := TemplateData{
+		Title:   i18n.T(requestLocale(r), "login.title"),
+		SSOName: middleware.OIDCProviderName(),
+		Next:    options.Next,
 	}
 
-	// Execute layout template
-	err = layoutTmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Layout template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-	}
+	renderPage(w, r, "admin/login", data)
 }
 
 // LoginSubmitHandler handles the POST /login route
@@ -67,96 +43,107 @@ func LoginSubmitHandler(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		log.Printf("Form parsing error: %v", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		renderErrorPage(w, r, http.StatusBadRequest)
 		return
 	}
 
 	// Get form values
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	options := loginOptions(r)
+
+	// Slow down repeated failures for this username or address
+	ip := middleware.ClientIP(r)
+	if !checkLoginThrottle(w, r, username, ip, "admin/login", i18n.T(requestLocale(r), "login.title")) {
+		return
+	}
 
 	// Authenticate user
-	if !middleware.Authenticate(username, password) {
-		// Authentication failed
-		// Prepare template data
-		data := TemplateData{
-			Title: "Login",
-			Error: "Invalid username or password",
-		}
+	user, ok := middleware.Authenticate(username, password)
+	if !ok {
+		middleware.RecordLoginResult(username, ip, false)
+		renderLoginError(w, r, options.Next, i18n.T(requestLocale(r), "login.invalid"))
+		return
+	}
 
-		// First, render the content template
-		contentTmpl, err := template.ParseFiles("src/views/admin/login.html")
-		if err != nil {
-			log.Printf("Content template parsing error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	// Users with two-factor authentication still have to enter a code, which is
+	// checked as an attempt of its own
+	if user.TOTPEnabled {
+		middleware.ReleaseLoginAttempt(username, ip)
+		startTwoFactorLogin(w, r, user, options)
+		return
+	}
 
-		// Execute content template to a buffer
-		var contentBuffer strings.Builder
-		err = contentTmpl.ExecuteTemplate(&contentBuffer, "login", data)
-		if err != nil {
-			log.Printf("Content template execution error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+	signIn(w, r, user, options)
+}
 
-		// Add the rendered content to the data
-		data.Content = template.HTML(contentBuffer.String())
-
-		// Parse layout template
-		layoutTmpl, err := template.ParseFiles(
-			"src/views/layout.html",
-			"src/views/home/hero.html",
-			"src/views/home/footer.html",
-			"src/views/home/section.html",
-		)
-		if err != nil {
-			log.Printf("Layout template parsing error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+// loginOptions returns the choices sent with the login page or form
+func loginOptions(r *http.Request) middleware.LoginOptions {
+	return middleware.LoginOptions{
+		Remember: r.FormValue("remember") != "",
+		Next:     middleware.SafeRedirectPath(r.FormValue("next")),
+	}
+}
 
-		// Execute layout template
-		err = layoutTmpl.Execute(w, data)
-		if err != nil {
-			log.Printf("Layout template execution error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
+// checkLoginThrottle answers with the login page and a 429 status when sign-in
+// attempts for the username or address have to wait, and reports whether to go ahead
+func checkLoginThrottle(w http.ResponseWriter, r *http.Request, username, ip, page, title string) bool {
+	err := middleware.CheckLoginThrottle(username, ip)
+	if err == nil {
+		return true
+	}
+
+	var throttled *middleware.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		handleError(w, r, fmt.Errorf("checking login throttle: %w", err))
+		return false
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:   title,
+		Error:   throttledMessage(requestLocale(r), throttled.RetryAfter),
+		SSOName: middleware.OIDCProviderName(),
+		Next:    middleware.SafeRedirectPath(r.FormValue("next")),
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds()+0.5)))
+	renderPageStatus(w, r, http.StatusTooManyRequests, page, data)
+	return false
+}
+
+// throttledMessage asks to wait before trying to sign in again, in seconds or
+// whole minutes
+func throttledMessage(locale string, wait time.Duration) string {
+	if wait < time.Minute {
+		return i18n.N(locale, "login.throttled_seconds", int(math.Ceil(wait.Seconds())))
 	}
+	return i18n.N(locale, "login.throttled_minutes", int(math.Ceil(wait.Minutes())))
+}
 
-	// Authentication successful, generate token
-	token, err := middleware.GenerateToken(username)
+// signIn starts a session for an authenticated user and sends them back to the
+// page they came from, or to the dashboard
+func signIn(w http.ResponseWriter, r *http.Request, user models.User, options middleware.LoginOptions) {
+	err := middleware.StartSession(w, r, user, options.Remember)
 	if err != nil {
-		log.Printf("Token generation error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		middleware.ReleaseLoginAttempt(user.Username, middleware.ClientIP(r))
+		handleError(w, r, fmt.Errorf("starting session: %w", err))
 		return
 	}
+	middleware.RecordLoginResult(user.Username, middleware.ClientIP(r), true)
 
-	// Set token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: true,
-		Path:     "/",
-	})
-
-	// Redirect to admin dashboard
-	http.Redirect(w, r, "/owner", http.StatusSeeOther)
+	// Redirect to the requested page or the admin dashboard
+	http.Redirect(w, r, options.Redirect(), http.StatusSeeOther)
 }
 
 // LogoutHandler handles the POST /logout route
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Clear token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		Expires:  time.Now().Add(-1 * time.Hour),
-		HttpOnly: true,
-		Path:     "/",
-	})
+	// Revoke the session so the token stops working everywhere
+	if err := middleware.EndSession(r); err != nil {
+		log.Printf("Error ending session: %v", err)
+	}
+
+	middleware.ClearSessionCookies(w)
 
 	// Redirect to home page
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -164,109 +151,99 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
 // DashboardHandler handles the GET /owner route
 func DashboardHandler(w http.ResponseWriter, r *http.Request) {
-	// Get all posts
-	posts, err := models.GetAllPosts()
+	// Get the signed-in user
+	user, err := currentUser(r)
 	if err != nil {
-		log.Printf("Error getting posts: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
 		return
 	}
 
-	// Prepare template data
-	data := TemplateData{
-		Title:   "Admin Dashboard",
-		Posts:   posts,
-		IsAdmin: true,
-	}
-
-	// First, render the content template
-	contentTmpl, err := template.ParseFiles("src/views/admin/dashboard.html")
+	// Get the posts the user works on
+	posts, err := dashboardPosts(user)
 	if err != nil {
-		log.Printf("Content template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("getting posts: %w", err))
 		return
 	}
 
-	// Execute content template to a buffer
-	var contentBuffer strings.Builder
-	err = contentTmpl.ExecuteTemplate(&contentBuffer, "dashboard", data)
-	if err != nil {
-		log.Printf("Content template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	// Prepare template data
+	data := TemplateData{
+		Title: i18n.T(requestLocale(r), "dashboard.page_title"),
+		Posts: posts,
+		User:  user,
 	}
 
-	// Add the rendered content to the data
-	data.Content = template.HTML(contentBuffer.String())
+	renderPage(w, r, "admin/dashboard", data)
+}
 
-	// Parse layout template
-	layoutTmpl, err := template.ParseFiles(
-		"src/views/layout.html",
-		"src/views/home/hero.html",
-		"src/views/home/footer.html",
-		"src/views/home/section.html",
-	)
-	if err != nil {
-		log.Printf("Layout template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	// Execute layout template
-	err = layoutTmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Layout template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// dashboardPosts returns the posts shown to a user on the dashboard. Authors only see
+// their own posts and editors all of them, while users who can't edit any posts see
+// the published ones, like readers do.
+func dashboardPosts(user models.User) ([]models.Post, error) {
+	switch {
+	case user.Can(models.PermEditAnyPost):
+		return models.GetAllPosts()
+	case user.Can(models.PermWritePosts):
+		return models.GetPostsByAuthor(user.ID)
+	default:
+		return models.GetPublishedPosts()
 	}
 }
 
-// NewPostHandler handles the GET /owner/new route
-func NewPostHandler(w http.ResponseWriter, r *http.Request) {
-	// Prepare template data
-	data := TemplateData{
-		Title:   "New Post",
-		IsAdmin: true,
+// renderPostForm renders the post form, listing the posts the one being written can
+// be a translation of
+func renderPostForm(w http.ResponseWriter, r *http.Request, status int, data TemplateData) {
+	// Authors can't see the drafts of others, unless they are already translations
+	var posts []models.Post
+	var err error
+	if data.User.Can(models.PermEditAnyPost) {
+		posts, err = models.GetAllPosts()
+	} else {
+		posts, err = models.GetTranslatablePosts(data.User.ID, data.Post.TranslationGroup)
 	}
-
-	// First, render the content template
-	contentTmpl, err := template.ParseFiles("src/views/admin/post_form.html")
 	if err != nil {
-		log.Printf("Content template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("getting posts: %w", err))
 		return
 	}
+	data.Posts = posts
 
-	// Execute content template to a buffer
-	var contentBuffer strings.Builder
-	err = contentTmpl.ExecuteTemplate(&contentBuffer, "post-form", data)
-	if err != nil {
-		log.Printf("Content template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
+	renderPageStatus(w, r, status, "admin/post_form", data)
+}
 
-	// Add the rendered content to the data
-	data.Content = template.HTML(contentBuffer.String())
+// postFormError returns the message shown on the post form for a value that can't
+// be saved, falling back to the error's own text for fields without one
+func postFormError(locale string, err error) string {
+	var validation *models.ValidationError
+	var conflict *models.ConflictError
+	key := ""
+	switch {
+	case errors.As(err, &validation):
+		key = "post_form.invalid_" + validation.Field
+	case errors.As(err, &conflict):
+		key = "post_form.taken_" + conflict.Field
+	}
+	if !i18n.Has(locale, key) {
+		return err.Error()
+	}
+	return i18n.T(locale, key)
+}
 
-	// Parse layout template
-	layoutTmpl, err := template.ParseFiles(
-		"src/views/layout.html",
-		"src/views/home/hero.html",
-		"src/views/home/footer.html",
-		"src/views/home/section.html",
-	)
+// NewPostHandler handles the GET /owner/new route
+func NewPostHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the signed-in user
+	user, err := currentUser(r)
 	if err != nil {
-		log.Printf("Layout template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
 		return
 	}
 
-	// Execute layout template
-	err = layoutTmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Layout template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Prepare template data, writing in the language of the dashboard by default
+	data := TemplateData{
+		Title: i18n.T(requestLocale(r), "post_form.new_title"),
+		Post:  models.Post{Lang: requestLocale(r)},
+		User:  user,
 	}
+
+	renderPostForm(w, r, http.StatusOK, data)
 }
 
 // CreatePostHandler handles the POST /owner/new route
@@ -275,71 +252,82 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		log.Printf("Form parsing error: %v", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get the signed-in user as the author
+	author, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
 		return
 	}
 
 	// Get form values
 	title := r.FormValue("title")
+	summary := strings.TrimSpace(r.FormValue("summary"))
 	content := r.FormValue("content")
+	tags := models.NormalizeTags(strings.Split(r.FormValue("tags"), ","))
+	lang, langErr := postLang(r, r.FormValue("lang"))
+	translationGroup, translationErr := translationGroupOf(r, author, r.FormValue("translation_of"), 0)
+	if translationErr != nil && !errors.Is(translationErr, models.ErrValidation) {
+		handleError(w, r, fmt.Errorf("getting translated post: %w", translationErr))
+		return
+	}
+
+	// Posts start as drafts unless the author may publish them
+	published := author.Can(models.PermPublishPosts) && r.FormValue("published") == "on"
+
+	// Keep the form values so nothing typed is lost if the post can't be saved
+	post := models.Post{
+		Title:            title,
+		Summary:          summary,
+		Content:          content,
+		Published:        published,
+		Tags:             tags,
+		Lang:             lang,
+		TranslationGroup: translationGroup,
+	}
 
 	// Validate form
-	if title == "" || content == "" {
+	formError := ""
+	switch {
+	case title == "" || content == "":
+		formError = i18n.T(requestLocale(r), "post_form.required")
+	case langErr != nil:
+		formError = postFormError(requestLocale(r), langErr)
+	case translationErr != nil:
+		formError = postFormError(requestLocale(r), translationErr)
+	}
+	if formError != "" {
 		// Prepare template data with error
 		data := TemplateData{
-			Title:   "New Post",
-			Error:   "Title and content are required",
-			Post:    models.Post{Title: title, Content: content},
-			IsAdmin: true,
+			Title: i18n.T(requestLocale(r), "post_form.new_title"),
+			Error: formError,
+			Post:  post,
+			User:  author,
 		}
 
-		// First, render the content template
-		contentTmpl, err := template.ParseFiles("src/views/admin/post_form.html")
-		if err != nil {
-			log.Printf("Content template parsing error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-
-		// Execute content template to a buffer
-		var contentBuffer strings.Builder
-		err = contentTmpl.ExecuteTemplate(&contentBuffer, "post-form", data)
-		if err != nil {
-			log.Printf("Content template execution error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+		renderPostForm(w, r, http.StatusOK, data)
+		return
+	}
 
-		// Add the rendered content to the data
-		data.Content = template.HTML(contentBuffer.String())
-
-		// Parse layout template
-		layoutTmpl, err := template.ParseFiles(
-			"src/views/layout.html",
-			"src/views/home/hero.html",
-			"src/views/home/footer.html",
-			"src/views/home/section.html",
-		)
-		if err != nil {
-			log.Printf("Layout template parsing error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+	// Create post
+	_, err = models.CreatePost(title, summary, content, lang, tags, translationGroup, author.ID, published)
+	if errors.Is(err, models.ErrConflict) {
+		// Prepare template data with error
+		data := TemplateData{
+			Title: i18n.T(requestLocale(r), "post_form.new_title"),
+			Error: postFormError(requestLocale(r), err),
+			Post:  post,
+			User:  author,
 		}
 
-		// Execute layout template
-		err = layoutTmpl.Execute(w, data)
-		if err != nil {
-			log.Printf("Layout template execution error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
+		renderPostForm(w, r, http.StatusConflict, data)
 		return
 	}
-
-	// Create post
-	_, err = models.CreatePost(title, content)
 	if err != nil {
-		log.Printf("Error creating post: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("creating post: %w", err))
 		return
 	}
 
@@ -355,57 +343,25 @@ func EditPostHandler(w http.ResponseWriter, r *http.Request) {
 	// Get post by slug
 	post, err := models.GetPostBySlug(slug)
 	if err != nil {
-		log.Printf("Error getting post: %v", err)
-		http.Error(w, "Post not found", http.StatusNotFound)
-		return
-	}
-
-	// Prepare template data
-	data := TemplateData{
-		Title:   "Edit Post",
-		Post:    post,
-		IsAdmin: true,
-	}
-
-	// First, render the content template
-	contentTmpl, err := template.ParseFiles("src/views/admin/post_form.html")
-	if err != nil {
-		log.Printf("Content template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("getting post: %w", err))
 		return
 	}
 
-	// Execute content template to a buffer
-	var contentBuffer strings.Builder
-	err = contentTmpl.ExecuteTemplate(&contentBuffer, "post-form", data)
-	if err != nil {
-		log.Printf("Content template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Check the user may edit this post
+	user, err := currentUser(r)
+	if err != nil || !user.CanEditPost(post) {
+		ForbiddenHandler(w, r)
 		return
 	}
 
-	// Add the rendered content to the data
-	data.Content = template.HTML(contentBuffer.String())
-
-	// Parse layout template
-	layoutTmpl, err := template.ParseFiles(
-		"src/views/layout.html",
-		"src/views/home/hero.html",
-		"src/views/home/footer.html",
-		"src/views/home/section.html",
-	)
-	if err != nil {
-		log.Printf("Layout template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	// Prepare template data
+	data := TemplateData{
+		Title: i18n.T(requestLocale(r), "post_form.edit_title"),
+		Post:  post,
+		User:  user,
 	}
 
-	// Execute layout template
-	err = layoutTmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Layout template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-	}
+	renderPostForm(w, r, http.StatusOK, data)
 }
 
 // UpdatePostHandler handles the POST /owner/edit/:slug route
@@ -417,83 +373,90 @@ func UpdatePostHandler(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		log.Printf("Form parsing error: %v", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get original post
+	post, err := models.GetPostBySlug(slug)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting post: %w", err))
+		return
+	}
+
+	// Check the user may edit this post
+	user, err := currentUser(r)
+	if err != nil || !user.CanEditPost(post) {
+		ForbiddenHandler(w, r)
 		return
 	}
 
 	// Get form values
 	title := r.FormValue("title")
+	summary := strings.TrimSpace(r.FormValue("summary"))
 	content := r.FormValue("content")
+	tags := models.NormalizeTags(strings.Split(r.FormValue("tags"), ","))
+	lang, langErr := postLang(r, r.FormValue("lang"))
+	translationGroup, translationErr := translationGroupOf(r, user, r.FormValue("translation_of"), post.TranslationGroup)
+	if translationErr != nil && !errors.Is(translationErr, models.ErrValidation) {
+		handleError(w, r, fmt.Errorf("getting translated post: %w", translationErr))
+		return
+	}
 
-	// Validate form
-	if title == "" || content == "" {
-		// Get original post
-		post, err := models.GetPostBySlug(slug)
-		if err != nil {
-			log.Printf("Error getting post: %v", err)
-			http.Error(w, "Post not found", http.StatusNotFound)
-			return
-		}
+	// Only users who may publish can change whether the post is published
+	published := post.Published
+	if user.Can(models.PermPublishPosts) {
+		published = r.FormValue("published") == "on"
+	}
 
-		// Update post with form values
-		post.Title = title
-		post.Content = content
+	// Keep the form values so nothing typed is lost if the post can't be saved
+	post.Title = title
+	post.Summary = summary
+	post.Content = content
+	post.Published = published
+	post.Tags = tags
+	post.Lang = lang
+	post.TranslationGroup = translationGroup
 
+	// Validate form
+	formError := ""
+	switch {
+	case title == "" || content == "":
+		formError = i18n.T(requestLocale(r), "post_form.required")
+	case langErr != nil:
+		formError = postFormError(requestLocale(r), langErr)
+	case translationErr != nil:
+		formError = postFormError(requestLocale(r), translationErr)
+	}
+	if formError != "" {
 		// Prepare template data with error
 		data := TemplateData{
-			Title:   "Edit Post",
-			Error:   "Title and content are required",
-			Post:    post,
-			IsAdmin: true,
-		}
-
-		// First, render the content template
-		contentTmpl, err := template.ParseFiles("src/views/admin/post_form.html")
-		if err != nil {
-			log.Printf("Content template parsing error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+			Title: i18n.T(requestLocale(r), "post_form.edit_title"),
+			Error: formError,
+			Post:  post,
+			User:  user,
 		}
 
-		// Execute content template to a buffer
-		var contentBuffer strings.Builder
-		err = contentTmpl.ExecuteTemplate(&contentBuffer, "post-form", data)
-		if err != nil {
-			log.Printf("Content template execution error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+		renderPostForm(w, r, http.StatusOK, data)
+		return
+	}
 
-		// Add the rendered content to the data
-		data.Content = template.HTML(contentBuffer.String())
-
-		// Parse layout template
-		layoutTmpl, err := template.ParseFiles(
-			"src/views/layout.html",
-			"src/views/home/hero.html",
-			"src/views/home/footer.html",
-			"src/views/home/section.html",
-		)
-		if err != nil {
-			log.Printf("Layout template parsing error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+	// Update post
+	_, err = models.UpdatePost(slug, title, summary, content, lang, tags, translationGroup, published)
+	if errors.Is(err, models.ErrConflict) {
+		// Prepare template data with error
+		data := TemplateData{
+			Title: i18n.T(requestLocale(r), "post_form.edit_title"),
+			Error: postFormError(requestLocale(r), err),
+			Post:  post,
+			User:  user,
 		}
 
-		// Execute layout template
-		err = layoutTmpl.Execute(w, data)
-		if err != nil {
-			log.Printf("Layout template execution error: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
+		renderPostForm(w, r, http.StatusConflict, data)
 		return
 	}
-
-	// Update post
-	_, err = models.UpdatePost(slug, title, content)
 	if err != nil {
-		log.Printf("Error updating post: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("updating post: %w", err))
 		return
 	}
 
@@ -506,11 +469,24 @@ func DeletePostHandler(w http.ResponseWriter, r *http.Request) {
 	// Get slug from URL
 	slug := chi.URLParam(r, "slug")
 
+	// Get post by slug
+	post, err := models.GetPostBySlug(slug)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting post: %w", err))
+		return
+	}
+
+	// Check the user may delete this post
+	user, err := currentUser(r)
+	if err != nil || !user.CanDeletePost(post) {
+		ForbiddenHandler(w, r)
+		return
+	}
+
 	// Delete post
-	err := models.DeletePost(slug)
+	err = models.DeletePost(slug)
 	if err != nil {
-		log.Printf("Error deleting post: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("deleting post: %w", err))
 		return
 	}
 
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiPostRequest is the body of the post create and update API calls.
+// Fields left out of an update keep their current value. TranslationOf is the slug
+// of the post this one translates, or empty for none.
+type apiPostRequest struct {
+	Title         *string   `json:"title"`
+	Summary       *string   `json:"summary"`
+	Content       *string   `json:"content"`
+	Tags          *[]string `json:"tags"`
+	Published     *bool     `json:"published"`
+	Lang          *string   `json:"lang"`
+	TranslationOf *string   `json:"translation_of"`
+}
+
+// RequireScope is a middleware that only lets through API requests whose token
+// was given the scope, answering everything else with a 403
+func RequireScope(scope models.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := middleware.APITokenFromContext(r.Context())
+			if !ok || !token.Has(scope) {
+				writeJSONError(w, http.StatusForbidden, "Token is missing the "+string(scope)+" scope")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIListPostsHandler handles the GET /api/v1/posts route
+func APIListPostsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	// Authors only see their own posts, everyone else sees all of them, like on the dashboard
+	var posts []models.Post
+	var err error
+	if user.Can(models.PermWritePosts) && !user.Can(models.PermEditAnyPost) {
+		posts, err = models.GetPostsByAuthor(user.ID)
+	} else {
+		posts, err = models.GetAllPosts()
+	}
+	if err != nil {
+		handleJSONError(w, r, fmt.Errorf("getting posts: %w", err))
+		return
+	}
+
+	// Drafts are only listed for those who may edit them
+	visible := []models.Post{}
+	for _, post := range posts {
+		if post.Published || user.CanEditPost(post) {
+			visible = append(visible, post)
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+// APIGetPostHandler handles the GET /api/v1/posts/:slug route
+func APIGetPostHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	// Get post by slug
+	post, err := models.GetPostBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		handleJSONError(w, r, fmt.Errorf("getting post: %w", err))
+		return
+	}
+	// Drafts are only visible to those who may edit them
+	if !post.Published && !user.CanEditPost(post) {
+		writeJSONError(w, http.StatusNotFound, "post not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, post)
+}
+
+// APICreatePostHandler handles the POST /api/v1/posts route
+func APICreatePostHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+	if !user.Can(models.PermWritePosts) {
+		writeJSONError(w, http.StatusForbidden, "You may not write posts")
+		return
+	}
+
+	// Decode request
+	var body apiPostRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	// Validate request
+	if body.Title == nil || *body.Title == "" || body.Content == nil || *body.Content == "" {
+		writeJSONError(w, http.StatusBadRequest, "Title and content are required")
+		return
+	}
+	var summary string
+	if body.Summary != nil {
+		summary = strings.TrimSpace(*body.Summary)
+	}
+	published := body.Published != nil && *body.Published
+	if published && !user.Can(models.PermPublishPosts) {
+		writeJSONError(w, http.StatusForbidden, "You may not publish posts")
+		return
+	}
+	var lang, translationOf string
+	var tags []string
+	if body.Tags != nil {
+		tags = *body.Tags
+	}
+	if body.Lang != nil {
+		lang = *body.Lang
+	}
+	if body.TranslationOf != nil {
+		translationOf = *body.TranslationOf
+	}
+	lang, err := postLang(r, lang)
+	if err != nil {
+		handleJSONError(w, r, err)
+		return
+	}
+	translationGroup, err := translationGroupOf(r, user, translationOf, 0)
+	if err != nil {
+		handleJSONError(w, r, fmt.Errorf("getting translated post: %w", err))
+		return
+	}
+
+	// Create post
+	post, err := models.CreatePost(*body.Title, summary, *body.Content, lang, tags, translationGroup, user.ID, published)
+	if err != nil {
+		handleJSONError(w, r, fmt.Errorf("creating post: %w", err))
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/posts/"+post.Slug)
+	writeJSON(w, http.StatusCreated, post)
+}
+
+// APIUpdatePostHandler handles the PUT /api/v1/posts/:slug route
+func APIUpdatePostHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	// Get post by slug
+	post, err := models.GetPostBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		handleJSONError(w, r, fmt.Errorf("getting post: %w", err))
+		return
+	}
+	if !user.CanEditPost(post) {
+		writeJSONError(w, http.StatusForbidden, "You may not edit this post")
+		return
+	}
+
+	// Decode request
+	var body apiPostRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	// Apply the given fields
+	if body.Title != nil {
+		post.Title = *body.Title
+	}
+	if body.Summary != nil {
+		post.Summary = strings.TrimSpace(*body.Summary)
+	}
+	if body.Content != nil {
+		post.Content = *body.Content
+	}
+	if body.Tags != nil {
+		post.Tags = *body.Tags
+	}
+	if body.Published != nil && *body.Published != post.Published {
+		if !user.Can(models.PermPublishPosts) {
+			writeJSONError(w, http.StatusForbidden, "You may not publish or unpublish posts")
+			return
+		}
+		post.Published = *body.Published
+	}
+	if body.Lang != nil {
+		post.Lang, err = postLang(r, *body.Lang)
+		if err != nil {
+			handleJSONError(w, r, err)
+			return
+		}
+	}
+	if body.TranslationOf != nil {
+		post.TranslationGroup, err = translationGroupOf(r, user, *body.TranslationOf, post.TranslationGroup)
+		if err != nil {
+			handleJSONError(w, r, fmt.Errorf("getting translated post: %w", err))
+			return
+		}
+	}
+
+	// Validate request
+	if post.Title == "" || post.Content == "" {
+		writeJSONError(w, http.StatusBadRequest, "Title and content are required")
+		return
+	}
+
+	// Update post
+	post, err = models.UpdatePost(post.Slug, post.Title, post.Summary, post.Content, post.Lang, post.Tags, post.TranslationGroup, post.Published)
+	if err != nil {
+		handleJSONError(w, r, fmt.Errorf("updating post: %w", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, post)
+}
+
+// APIDeletePostHandler handles the DELETE /api/v1/posts/:slug route
+func APIDeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := currentUser(r)
+
+	// Get post by slug
+	post, err := models.GetPostBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		handleJSONError(w, r, fmt.Errorf("getting post: %w", err))
+		return
+	}
+	if !user.CanDeletePost(post) {
+		writeJSONError(w, http.StatusForbidden, "You may not delete this post")
+		return
+	}
+
+	// Delete post
+	err = models.DeletePost(post.Slug)
+	if err != nil {
+		handleJSONError(w, r, fmt.Errorf("deleting post: %w", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+	"chewawi_web/src/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// AuthorHandler handles the GET /authors/:username route
+func AuthorHandler(w http.ResponseWriter, r *http.Request) {
+	// Get username from URL
+	username := chi.URLParam(r, "username")
+
+	// Get author by username
+	author, err := models.GetUserByUsername(username)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting author: %w", err))
+		return
+	}
+
+	// Get the author's posts
+	posts, err := models.GetPublishedPostsByAuthor(author.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting posts: %w", err))
+		return
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:  author.Name(),
+		Author: author,
+		Posts:  posts,
+		Meta: PageMeta{
+			Description: utils.TruncateWords(author.Bio, descriptionLength),
+			Type:        "profile",
+			Image:       author.AvatarURL,
+		},
+	}
+
+	renderPage(w, r, "authors/profile", data)
+}
+
+// EditProfileHandler handles the GET /owner/profile route
+func EditProfileHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:  i18n.T(requestLocale(r), "profile.title"),
+		Author: user,
+		User:   user,
+	}
+
+	renderPage(w, r, "admin/profile_form", data)
+}
+
+// UpdateProfileHandler handles the POST /owner/profile route
+func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Validate the email address before saving anything
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email != "" && !validEmail(email) {
+		renderProfileError(w, r, user, i18n.T(requestLocale(r), "admin.invalid_email"))
+		return
+	}
+
+	// Update profile
+	user, err = models.UpdateUserProfile(user.ID, r.FormValue("display_name"), r.FormValue("bio"), r.FormValue("avatar_url"))
+	if err != nil {
+		handleError(w, r, fmt.Errorf("updating profile: %w", err))
+		return
+	}
+
+	if email != user.Email {
+		_, err = models.SetUserEmail(user.ID, email)
+		if errors.Is(err, models.ErrConflict) {
+			renderProfileError(w, r, user, i18n.T(requestLocale(r), "profile.email_taken"))
+			return
+		}
+		if err != nil {
+			handleError(w, r, fmt.Errorf("updating email: %w", err))
+			return
+		}
+	}
+
+	// Redirect to the public author page
+	http.Redirect(w, r, "/authors/"+user.Username, http.StatusSeeOther)
+}
+
+// renderProfileError renders the profile form again with an error
+func renderProfileError(w http.ResponseWriter, r *http.Request, user models.User, errorMessage string) {
+	// Prepare template data
+	data := TemplateData{
+		Title:  i18n.T(requestLocale(r), "profile.title"),
+		Error:  errorMessage,
+		Author: user,
+		User:   user,
+	}
+
+	renderPage(w, r, "admin/profile_form", data)
+}
+
+// currentUser returns the signed-in user of the request
+func currentUser(r *http.Request) (models.User, error) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		return models.User{}, errors.New("not signed in")
+	}
+	return user, nil
+}
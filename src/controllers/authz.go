@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"net/http"
+
+	"chewawi_web/src/models"
+)
+
+// RequirePermission is a middleware that only lets through signed-in users
+// whose role grants the given permission, answering everyone else with a 403 page
+func RequirePermission(permission models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := currentUser(r)
+			if err != nil || !user.Can(permission) {
+				ForbiddenHandler(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ForbiddenHandler renders the 403 page
+func ForbiddenHandler(w http.ResponseWriter, r *http.Request) {
+	renderErrorPage(w, r, http.StatusForbidden)
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/models"
+
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// errorStatus maps an error from the models to the status to answer with
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrValidation):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleError answers a request that failed with the error page for its status, or
+// JSON for clients asking for it. Unexpected errors are logged with the request ID
+// shown on the page, so a visitor's report can be matched to the log.
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if wantsJSON(r) {
+		handleJSONError(w, r, err)
+		return
+	}
+
+	status := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		log.Printf("[%s] %s %s: %v", chimiddleware.GetReqID(r.Context()), r.Method, r.URL.Path, err)
+	}
+
+	renderErrorPage(w, r, status)
+}
+
+// handleJSONError answers an API request that failed like handleError, with a JSON error
+func handleJSONError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		requestID := chimiddleware.GetReqID(r.Context())
+		log.Printf("[%s] %s %s: %v", requestID, r.Method, r.URL.Path, err)
+		writeJSON(w, status, map[string]string{"error": http.StatusText(status), "request_id": requestID})
+		return
+	}
+
+	// Errors from the models are safe to show, they only describe the request
+	writeJSONError(w, status, errorMessage(err))
+}
+
+// errorMessage returns the message of the model error wrapped in err, without the
+// context added around it on the way up
+func errorMessage(err error) string {
+	var notFound *models.NotFoundError
+	var conflict *models.ConflictError
+	var validation *models.ValidationError
+	switch {
+	case errors.As(err, &notFound):
+		return notFound.Error()
+	case errors.As(err, &conflict):
+		return conflict.Error()
+	case errors.As(err, &validation):
+		return validation.Error()
+	default:
+		return err.Error()
+	}
+}
+
+// renderErrorPage renders the themed page for an error status, or a JSON error for
+// clients asking for JSON
+func renderErrorPage(w http.ResponseWriter, r *http.Request, status int) {
+	if wantsJSON(r) {
+		writeJSONError(w, status, http.StatusText(status))
+		return
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+	if key := "status." + strconv.Itoa(status); i18n.Has(requestLocale(r), key) {
+		data.Title = i18n.T(requestLocale(r), key)
+	}
+
+	// Server errors show the request ID to quote when reporting them
+	if status >= http.StatusInternalServerError {
+		data.RequestID = chimiddleware.GetReqID(r.Context())
+	}
+
+	// Statuses without a page of their own share the generic one
+	page := "errors/error"
+	switch status {
+	case http.StatusForbidden:
+		page = "errors/403"
+	case http.StatusNotFound:
+		page = "errors/404"
+	case http.StatusInternalServerError:
+		page = "errors/500"
+	}
+
+	renderPageStatus(w, r, status, page, data)
+}
+
+// NotFoundHandler renders the 404 page for routes that don't exist
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	renderErrorPage(w, r, http.StatusNotFound)
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"net/http"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SwitchLocaleHandler handles the GET /language/:locale route
+func SwitchLocaleHandler(w http.ResponseWriter, r *http.Request) {
+	// Get locale from URL
+	locale, ok := i18n.Match(chi.URLParam(r, "locale"))
+	if !ok {
+		renderErrorPage(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Remember the choice, then go back to the page it was made on
+	middleware.SetLocaleCookie(w, locale)
+
+	next := middleware.SafeRedirectPath(r.URL.Query().Get("next"))
+	if next == "" {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
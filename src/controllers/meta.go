@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/models"
+	"chewawi_web/src/utils"
+)
+
+const (
+	// siteName is the name of the site, shown in titles and link previews
+	siteName = "Chewawi"
+	// siteDescription describes pages that don't have a description of their own
+	siteDescription = "how can you see into my eyes, like open doors"
+	// descriptionLength is the longest description generated from a post's text
+	descriptionLength = 160
+)
+
+// PageMeta holds the metadata in a page's head for search engines and link previews
+type PageMeta struct {
+	Title        string
+	Description  string
+	CanonicalURL string
+	// Type is the Open Graph type, such as "website", "article" or "profile"
+	Type          string
+	Image         string
+	Author        string
+	PublishedTime time.Time
+	ModifiedTime  time.Time
+	// StructuredData holds schema.org objects placed in the head as JSON-LD
+	StructuredData []any
+	// Alternates are the versions of the page in each language, itself included
+	Alternates []Alternate
+}
+
+// Alternate is a version of a page in a language, linked with hreflang
+type Alternate struct {
+	// Lang is a language code, or "x-default" for the version shown to the rest
+	Lang string
+	URL  string
+}
+
+// DocumentTitle returns the title for the browser tab, with the site name after the page's
+func (m PageMeta) DocumentTitle() string {
+	if m.Title == "" || m.Title == siteName {
+		return siteName
+	}
+	return m.Title + " | " + siteName
+}
+
+// TwitterCard returns the kind of Twitter card, with a large image when there is one
+func (m PageMeta) TwitterCard() string {
+	if m.Image != "" {
+		return "summary_large_image"
+	}
+	return "summary"
+}
+
+// IsArticle reports whether the page is an article with publication times
+func (m PageMeta) IsArticle() bool {
+	return m.Type == "article"
+}
+
+// fillPageMeta fills in the metadata a handler left out from the page and request
+func fillPageMeta(r *http.Request, data *TemplateData) {
+	meta := &data.Meta
+	if meta.Title == "" {
+		meta.Title = data.Title
+	}
+	if meta.Description == "" {
+		meta.Description = siteDescription
+	}
+	if meta.CanonicalURL == "" {
+		// Query strings are left out, so sorted or tracked links count as one page
+		meta.CanonicalURL = absoluteURL(r.URL.EscapedPath())
+	}
+	if meta.Type == "" {
+		meta.Type = "website"
+	}
+	meta.Image = absoluteURL(meta.Image)
+
+	// Describe the breadcrumbs shown by the layout
+	if data.Title != siteName {
+		meta.StructuredData = append(meta.StructuredData, breadcrumbData(i18n.T(requestLocale(r), "nav.home"), data.Title, meta.CanonicalURL))
+	}
+}
+
+// postMeta returns the metadata of a post's page. The description is the post's
+// summary, or else the start of its first paragraph.
+func postMeta(post models.Post, renderedHTML string) PageMeta {
+	description := post.Summary
+	if description == "" {
+		description = utils.Excerpt(renderedHTML, descriptionLength)
+	}
+
+	// Preview the post with its first image, or else its author
+	image := utils.FirstImage(renderedHTML)
+	if image == "" {
+		image = post.Author.AvatarURL
+	}
+
+	return PageMeta{
+		Title:         post.Title,
+		Description:   description,
+		CanonicalURL:  absoluteURL("/posts/" + url.PathEscape(post.Slug)),
+		Type:          "article",
+		Image:         image,
+		Author:        post.Author.Name(),
+		PublishedTime: post.Created,
+		ModifiedTime:  post.Updated,
+	}
+}
+
+// translationAlternates returns the alternates of a post with translations, the
+// version in the default language also standing for the rest. A post without
+// translations has none.
+func translationAlternates(post models.Post, translations []models.Post) []Alternate {
+	if len(translations) == 0 {
+		return nil
+	}
+
+	versions := append([]models.Post{post}, translations...)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Lang < versions[j].Lang })
+
+	alternates := make([]Alternate, 0, len(versions)+1)
+	for _, version := range versions {
+		link := absoluteURL("/posts/" + url.PathEscape(version.Slug))
+		alternates = append(alternates, Alternate{Lang: version.Lang, URL: link})
+		if version.Lang == i18n.DefaultLocale {
+			alternates = append(alternates, Alternate{Lang: "x-default", URL: link})
+		}
+	}
+	return alternates
+}
+
+// absoluteURL resolves a link against the site's public address, since crawlers
+// need full URLs. Empty links stay empty.
+func absoluteURL(link string) string {
+	if link == "" {
+		return ""
+	}
+
+	// Paths on the site go below SITE_URL, which may have a path of its own
+	if strings.HasPrefix(link, "/") && !strings.HasPrefix(link, "//") {
+		return siteURL() + link
+	}
+
+	base, err := url.Parse(siteURL() + "/")
+	if err != nil {
+		return link
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chewawi_web/src/middleware"
+)
+
+// jsonSuffix asks for the JSON representation of a page in its path, like /posts.json
+const jsonSuffix = ".json"
+
+// negotiateJSON reports whether to answer a page that has both representations with
+// JSON. Both depend on the Accept header and, through the session, on cookies, which
+// it declares in Vary so caches keep them apart.
+func negotiateJSON(w http.ResponseWriter, r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, jsonSuffix) {
+		middleware.AddVary(w.Header(), "Cookie")
+		return true
+	}
+
+	middleware.AddVary(w.Header(), "Accept", "Cookie")
+	return wantsJSON(r)
+}
+
+// wantsJSON reports whether the client asked for JSON, with a .json suffix on the
+// path or an Accept header preferring it to HTML
+func wantsJSON(r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, jsonSuffix) {
+		return true
+	}
+	return preferredType(r.Header.Get("Accept"), "text/html", "application/json") == "application/json"
+}
+
+// preferredType returns the offered media type the Accept header rates highest. On a
+// tie, a type the header names beats one it only matches with a wildcard, and then
+// the first offer wins, as it does without a header. It returns an empty string when
+// every offer is refused with q=0.
+func preferredType(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQuality, bestSpecificity := "", 0.0, -1
+	for _, offer := range offers {
+		quality, specificity := acceptQuality(accept, offer)
+		if quality > bestQuality || (quality == bestQuality && quality > 0 && specificity > bestSpecificity) {
+			best, bestQuality, bestSpecificity = offer, quality, specificity
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q value the Accept header gives a media type, taken from
+// the most specific range that matches it, along with how specific that range is:
+// 2 for type/subtype, 1 for type/* and 0 for */*
+func acceptQuality(accept, mediaType string) (float64, int) {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		// Rank how closely the range matches
+		rank := -1
+		switch accepted {
+		case mediaType:
+			rank = 2
+		case mainType + "/*":
+			rank = 1
+		case "*/*":
+			rank = 0
+		}
+		if rank <= specificity {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil && parsed >= 0 && parsed <= 1 {
+				q = parsed
+			}
+		}
+		quality, specificity = q, rank
+	}
+	return quality, specificity
+}
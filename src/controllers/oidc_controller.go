@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+)
+
+// oidcCookie holds the state of the login waiting for the identity provider
+const oidcCookie = "oidc"
+
+// OIDCLoginHandler handles the GET /login/oidc route
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	options := loginOptions(r)
+	redirectURL, state, err := middleware.BeginOIDCLogin(r.Context(), options)
+	if err != nil {
+		log.Printf("Error starting single sign-on: %v", err)
+		renderLoginError(w, r, options.Next, i18n.T(requestLocale(r), "login.sso_unavailable"))
+		return
+	}
+
+	// Remember the state so only this browser can finish the login; Lax lets
+	// the cookie come along on the redirect back from the identity provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state,
+		Path:     "/login/oidc",
+		Expires:  time.Now().Add(10 * time.Minute),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler handles the GET /login/oidc/callback route
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	// Clear the pending login whatever happens
+	cookie, err := r.Cookie(oidcCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    "",
+		Path:     "/login/oidc",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// The identity provider reports refusals as an error parameter
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		log.Printf("Single sign-on refused: %s: %s", providerError, query.Get("error_description"))
+		renderLoginError(w, r, "", i18n.T(requestLocale(r), "login.sso_refused"))
+		return
+	}
+
+	// The state must be the one this browser started with
+	if err != nil || cookie.Value == "" || cookie.Value != query.Get("state") {
+		renderLoginError(w, r, "", i18n.T(requestLocale(r), "login.sso_expired"))
+		return
+	}
+
+	user, options, err := middleware.FinishOIDCLogin(r.Context(), cookie.Value, query.Get("code"))
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		renderLoginError(w, r, options.Next, i18n.T(requestLocale(r), "login.sso_failed"))
+		return
+	}
+
+	// The identity provider is responsible for second factors
+	signIn(w, r, user, options)
+}
+
+// renderLoginError renders the login page with an error, keeping the page to
+// return to after signing in
+func renderLoginError(w http.ResponseWriter, r *http.Request, next, errorMessage string) {
+	// Prepare template data
+	data := TemplateData{
+		Title:   i18n.T(requestLocale(r), "login.title"),
+		Error:   errorMessage,
+		SSOName: middleware.OIDCProviderName(),
+		Next:    next,
+	}
+
+	renderPage(w, r, "admin/login", data)
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// passkeyCookie holds the ID of the WebAuthn ceremony in progress
+const passkeyCookie = "webauthn"
+
+// PasskeysHandler handles the GET /owner/passkeys route
+func PasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Get the user's passkeys
+	passkeys, err := models.GetPasskeysByUser(user.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting passkeys: %w", err))
+		return
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:    i18n.T(requestLocale(r), "passkeys.title"),
+		User:     user,
+		Passkeys: passkeys,
+	}
+
+	renderPage(w, r, "admin/passkeys", data)
+}
+
+// BeginPasskeyRegistrationHandler handles the POST /owner/passkeys/register/begin route
+func BeginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		log.Printf("Error getting current user: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	creation, ceremonyID, err := middleware.BeginPasskeyRegistration(user)
+	if err != nil {
+		log.Printf("Error starting passkey registration: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	setPasskeyCookie(w, ceremonyID)
+	writeJSON(w, http.StatusOK, creation)
+}
+
+// FinishPasskeyRegistrationHandler handles the POST /owner/passkeys/register/finish route
+func FinishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		log.Printf("Error getting current user: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	cookie, err := r.Cookie(passkeyCookie)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "No passkey registration in progress")
+		return
+	}
+	clearPasskeyCookie(w)
+
+	err = middleware.FinishPasskeyRegistration(user, cookie.Value, r.URL.Query().Get("name"), r)
+	if err != nil {
+		log.Printf("Error registering passkey: %v", err)
+		writeJSONError(w, http.StatusBadRequest, "Passkey registration failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": "/owner/passkeys"})
+}
+
+// DeletePasskeyHandler handles the POST /owner/passkeys/:id/delete route
+func DeletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	// Get passkey ID from URL
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		renderErrorPage(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Delete passkey
+	err = models.DeletePasskey(id, user.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("deleting passkey: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, "/owner/passkeys", http.StatusSeeOther)
+}
+
+// BeginPasskeyLoginHandler handles the POST /login/passkey/begin route
+func BeginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	assertion, ceremonyID, err := middleware.BeginPasskeyLogin()
+	if err != nil {
+		log.Printf("Error starting passkey login: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+
+	setPasskeyCookie(w, ceremonyID)
+	writeJSON(w, http.StatusOK, assertion)
+}
+
+// FinishPasskeyLoginHandler handles the POST /login/passkey/finish route
+func FinishPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(passkeyCookie)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "No passkey login in progress")
+		return
+	}
+	clearPasskeyCookie(w)
+
+	// Slow down repeated failures from this address
+	ip := middleware.ClientIP(r)
+	if !checkPasskeyLoginThrottle(w, "", ip) {
+		return
+	}
+
+	user, err := middleware.FinishPasskeyLogin(cookie.Value, r)
+	if err != nil {
+		log.Printf("Passkey login failed: %v", err)
+		middleware.RecordLoginResult(user.Username, ip, false)
+		writeJSONError(w, http.StatusUnauthorized, "Passkey sign-in failed")
+		return
+	}
+
+	// Accounts locked out by failed attempts stay locked for passkeys too; the
+	// address was already checked and reserved above
+	if !checkPasskeyLoginThrottle(w, user.Username, "") {
+		middleware.ReleaseLoginAttempt("", ip)
+		return
+	}
+
+	// Issue the same session as a password login
+	options := middleware.LoginOptions{
+		Remember: r.URL.Query().Get("remember") != "",
+		Next:     r.URL.Query().Get("next"),
+	}
+	err = middleware.StartSession(w, r, user, options.Remember)
+	if err != nil {
+		middleware.ReleaseLoginAttempt(user.Username, ip)
+		log.Printf("Token generation error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	middleware.RecordLoginResult(user.Username, ip, true)
+
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": options.Redirect()})
+}
+
+// checkPasskeyLoginThrottle answers with a 429 status when sign-in attempts for the
+// username or address have to wait, and reports whether to go ahead
+func checkPasskeyLoginThrottle(w http.ResponseWriter, username, ip string) bool {
+	err := middleware.CheckLoginThrottle(username, ip)
+	if err == nil {
+		return true
+	}
+
+	var throttled *middleware.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		log.Printf("Error checking login throttle: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds()+0.5)))
+	writeJSONError(w, http.StatusTooManyRequests, "Too many failed attempts, please try again in "+throttled.RetryAfter.Round(time.Second).String())
+	return false
+}
+
+// setPasskeyCookie remembers the WebAuthn ceremony in progress
+func setPasskeyCookie(w http.ResponseWriter, ceremonyID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeyCookie,
+		Value:    ceremonyID,
+		Path:     "/",
+		Expires:  time.Now().Add(5 * time.Minute),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearPasskeyCookie forgets the WebAuthn ceremony in progress
+func clearPasskeyCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeyCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		HttpOnly: true,
+	})
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+// writeJSONError writes an error message as a JSON response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/mail"
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+	"chewawi_web/src/views"
+)
+
+// passwordResetInterval is how long to wait before emailing the same user another reset link
+const passwordResetInterval = time.Minute
+
+// userTokenMails are the subject message and template of the emails carrying each
+// kind of token
+var userTokenMails = map[models.TokenPurpose]struct {
+	subject, name, path string
+}{
+	models.TokenPasswordReset: {"mail.reset.subject", "password-reset", "/password/reset"},
+	models.TokenInvite:        {"mail.invite.subject", "invite", "/invite"},
+}
+
+// mailData holds data to be passed to email templates
+type mailData struct {
+	// Language the email is written in
+	Lang     string
+	User     models.User
+	Inviter  models.User
+	Link     string
+	ValidFor string
+}
+
+// ForgotPasswordHandler handles the GET /password/forgot route
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	renderForgotPasswordPage(w, r, "", "")
+}
+
+// ForgotPasswordSubmitHandler handles the POST /password/forgot route
+func ForgotPasswordSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Find the user by email address or username
+	identifier := strings.TrimSpace(r.FormValue("identifier"))
+	var user models.User
+	if strings.Contains(identifier, "@") {
+		user, err = models.GetUserByEmail(identifier)
+	} else {
+		user, err = models.GetUserByUsername(identifier)
+	}
+
+	// Only users with an address can get a link, and at most one a minute
+	if err == nil && user.Email != "" {
+		recent, err := models.HasRecentUserToken(user.ID, models.TokenPasswordReset, passwordResetInterval)
+		if err != nil {
+			log.Printf("Error checking password reset tokens: %v", err)
+		} else if !recent {
+			// Send in the background so the answer takes as long whether the user exists or not
+			locale := requestLocale(r)
+			go func() {
+				if err := sendUserTokenMail(locale, user, models.User{}, models.TokenPasswordReset); err != nil {
+					log.Printf("Error sending password reset to %s: %v", user.Username, err)
+				}
+			}()
+		}
+	}
+
+	// Say the same thing either way, so the form doesn't tell who has an account
+	renderForgotPasswordPage(w, r, i18n.T(requestLocale(r), "password.link_sent"), "")
+}
+
+// ResetPasswordHandler handles the GET /password/reset route
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	showSetPasswordPage(w, r, models.TokenPasswordReset)
+}
+
+// ResetPasswordSubmitHandler handles the POST /password/reset route
+func ResetPasswordSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	submitSetPasswordPage(w, r, models.TokenPasswordReset)
+}
+
+// AcceptInviteHandler handles the GET /invite route
+func AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	showSetPasswordPage(w, r, models.TokenInvite)
+}
+
+// AcceptInviteSubmitHandler handles the POST /invite route
+func AcceptInviteSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	submitSetPasswordPage(w, r, models.TokenInvite)
+}
+
+// showSetPasswordPage shows the form to choose a password for the user of the token in the link
+func showSetPasswordPage(w http.ResponseWriter, r *http.Request, purpose models.TokenPurpose) {
+	token := r.URL.Query().Get("token")
+	user, err := middleware.UserFromToken(purpose, token)
+	if errors.Is(err, models.ErrInvalidUserToken) {
+		renderInvalidUserToken(w, r, purpose)
+		return
+	}
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting user of token: %w", err))
+		return
+	}
+
+	renderSetPasswordPage(w, r, purpose, token, user, "")
+}
+
+// submitSetPasswordPage uses up the token to set the password chosen on the form
+func submitSetPasswordPage(w http.ResponseWriter, r *http.Request, purpose models.TokenPurpose) {
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get form values
+	token := r.FormValue("token")
+	password := r.FormValue("password")
+
+	// Validate form
+	if password == "" || password != r.FormValue("password_confirm") {
+		user, err := middleware.UserFromToken(purpose, token)
+		if errors.Is(err, models.ErrInvalidUserToken) {
+			renderInvalidUserToken(w, r, purpose)
+			return
+		}
+		if err != nil {
+			handleError(w, r, fmt.Errorf("getting user of token: %w", err))
+			return
+		}
+		renderSetPasswordPage(w, r, purpose, token, user, i18n.T(requestLocale(r), "password.mismatch"))
+		return
+	}
+
+	// Set the password, using up the token
+	user, err := middleware.SetPasswordWithToken(purpose, token, password)
+	if errors.Is(err, models.ErrInvalidUserToken) {
+		renderInvalidUserToken(w, r, purpose)
+		return
+	}
+	if err != nil {
+		handleError(w, r, fmt.Errorf("setting password: %w", err))
+		return
+	}
+	log.Printf("Password set for %s with a %s token", user.Username, purpose)
+
+	// Prepare template data
+	data := TemplateData{
+		Title:   i18n.T(requestLocale(r), "login.title"),
+		Message: i18n.T(requestLocale(r), "password.set"),
+		SSOName: middleware.OIDCProviderName(),
+	}
+
+	renderPage(w, r, "admin/login", data)
+}
+
+// renderForgotPasswordPage renders the form to request a password reset link
+func renderForgotPasswordPage(w http.ResponseWriter, r *http.Request, message, errorMessage string) {
+	// Prepare template data
+	data := TemplateData{
+		Title:   i18n.T(requestLocale(r), "password.forgot_title"),
+		Message: message,
+		Error:   errorMessage,
+	}
+
+	renderPage(w, r, "admin/forgot_password", data)
+}
+
+// renderSetPasswordPage renders the form to choose a password with a token
+func renderSetPasswordPage(w http.ResponseWriter, r *http.Request, purpose models.TokenPurpose, token string, user models.User, errorMessage string) {
+	// The token is in the URL, keep it out of caches and other sites' logs
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	// Prepare template data
+	data := TemplateData{
+		Title:      i18n.T(requestLocale(r), "password.choose_title"),
+		Error:      errorMessage,
+		User:       user,
+		ResetToken: token,
+		Invite:     purpose == models.TokenInvite,
+	}
+
+	renderPage(w, r, "admin/set_password", data)
+}
+
+// renderInvalidUserToken tells the user their link can't be used anymore
+func renderInvalidUserToken(w http.ResponseWriter, r *http.Request, purpose models.TokenPurpose) {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	if purpose == models.TokenInvite {
+		renderLoginError(w, r, "", i18n.T(requestLocale(r), "password.invite_expired"))
+		return
+	}
+	renderForgotPasswordPage(w, r, "", i18n.T(requestLocale(r), "password.link_expired"))
+}
+
+// sendUserTokenMail issues a token with the purpose for the user and emails them the
+// link, written in the locale
+func sendUserTokenMail(locale string, user, inviter models.User, purpose models.TokenPurpose) error {
+	if user.Email == "" {
+		return errors.New("user has no email address")
+	}
+
+	token, err := middleware.IssueUserToken(user.ID, purpose)
+	if err != nil {
+		return err
+	}
+
+	// Render the email
+	tokenMail := userTokenMails[purpose]
+	data := mailData{
+		Lang:     locale,
+		User:     user,
+		Inviter:  inviter,
+		Link:     siteURL() + tokenMail.path + "?token=" + url.QueryEscape(token),
+		ValidFor: formatLifetime(locale, middleware.UserTokenLifetime(purpose)),
+	}
+
+	var body strings.Builder
+	if err := views.Mail(&body, tokenMail.name, data); err != nil {
+		return err
+	}
+
+	return mail.Send(mail.Message{
+		To:      user.Email,
+		Subject: i18n.T(locale, tokenMail.subject),
+		Body:    body.String(),
+	})
+}
+
+// siteURL returns the public address of the site that links in emails point to.
+// It comes from SITE_URL rather than the request, which anyone can forge.
+func siteURL() string {
+	if url := os.Getenv("SITE_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:8081"
+}
+
+// formatLifetime formats a token lifetime for people in the locale, like "1 hour"
+// or "7 days"
+func formatLifetime(locale string, d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return i18n.N(locale, "mail.days", int(d/(24*time.Hour)))
+	case d >= time.Hour && d%time.Hour == 0:
+		return i18n.N(locale, "mail.hours", int(d/time.Hour))
+	default:
+		return i18n.N(locale, "mail.minutes", int(d.Round(time.Minute)/time.Minute))
+	}
+}
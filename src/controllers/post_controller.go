@@ -1,153 +1,344 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
 	"chewawi_web/src/models"
 	"chewawi_web/src/utils"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// relatedPostsLimit is how many related posts are shown below a post
+const relatedPostsLimit = 3
+
+// allLanguages lists posts in every language, as the lang query parameter of /posts
+const allLanguages = "all"
+
 // TemplateData holds data to be passed to templates
 type TemplateData struct {
-	Title       string
-	Posts       []models.Post
-	Post        models.Post
-	HTMLContent template.HTML
-	Error       string
-	IsAdmin     bool
-	Content     template.HTML
+	Title        string
+	Posts        []models.Post
+	Post         models.Post
+	PreviousPost models.Post
+	NextPost     models.Post
+	RelatedPosts []models.Post
+	Author       models.User
+	User         models.User
+	Users        []models.User
+	Roles        []models.Role
+	Passkeys     []models.Passkey
+	HTMLContent  template.HTML
+	Error        string
+	Message      string
+	CSRFToken    string
+
+	// Page metadata for search engines and link previews, see fillPageMeta
+	Meta PageMeta
+
+	// Language the post listing is filtered to
+	PostLang string
+
+	// Published translations of the post
+	Translations []models.Post
+
+	// Error pages
+	Status    int
+	RequestID string
+
+	// Locale of the page, and the others it can be switched to
+	Lang       string
+	Locales    []i18n.Locale
+	RequestURI string
+
+	// Signed-in visitor, who is shown the admin links of the layout
+	CurrentUser *middleware.CurrentUser
+	CanEditPost bool
+
+	// Two-factor authentication
+	TOTPSecret        string
+	QRCode            template.URL
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+	RequireAdmin2FA   bool
+
+	// Sign-in
+	LoginAttempts []models.LoginAttempt
+	SSOName       string
+	Next          string
+
+	// Password reset and invitations
+	ResetToken string
+	Invite     bool
+
+	// Sessions
+	Sessions       []models.Session
+	CurrentSession string
+
+	// API tokens
+	APITokens       []models.APIToken
+	NewAPIToken     string
+	Scopes          []models.Scope
+	TokenExpiryDays []int
 }
 
-// ListPostsHandler handles the GET /posts route
-func ListPostsHandler(w http.ResponseWriter, r *http.Request) {
-	// Get all posts
-	posts, err := models.GetAllPosts()
-	if err != nil {
-		log.Printf("Error getting posts: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+// postJSON is the public JSON representation of a post: what its page shows, with
+// the Markdown as content. It's spelled out rather than embedding models.Post, so
+// the author's account and the post's internals stay private.
+type postJSON struct {
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Summary     string    `json:"summary"`
+	Content     string    `json:"content"`
+	HTML        string    `json:"html"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Lang        string    `json:"lang"`
+	Tags        []string  `json:"tags"`
+	Author      string    `json:"author"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Image       string    `json:"image,omitempty"`
+	WordCount   int       `json:"word_count"`
+}
+
+// postLinkJSON is the JSON representation of a post linked from another one
+type postLinkJSON struct {
+	Title string `json:"title"`
+	Slug  string `json:"slug"`
+	Lang  string `json:"lang"`
+	URL   string `json:"url"`
+}
+
+// newPostJSON builds the JSON representation of a post
+func newPostJSON(post models.Post) postJSON {
+	renderedHTML := post.ContentHTML()
+	meta := postMeta(post, renderedHTML)
+	tags := post.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return postJSON{
+		Slug:        post.Slug,
+		Title:       post.Title,
+		Summary:     post.Summary,
+		Content:     post.Content,
+		HTML:        renderedHTML,
+		Created:     post.Created,
+		Updated:     post.Updated,
+		Lang:        post.Lang,
+		Tags:        tags,
+		Author:      post.Author.Name(),
+		URL:         meta.CanonicalURL,
+		Description: meta.Description,
+		Image:       absoluteURL(meta.Image),
+		WordCount:   utils.WordCount(renderedHTML),
 	}
+}
 
-	// Prepare template data
-	data := TemplateData{
-		Title: "Blog Posts",
-		Posts: posts,
+// newPostLinkJSON builds the JSON representation of a linked post, or nil for the
+// empty post returned when there is none
+func newPostLinkJSON(post models.Post) *postLinkJSON {
+	if post.ID == 0 {
+		return nil
+	}
+	return &postLinkJSON{
+		Title: post.Title,
+		Slug:  post.Slug,
+		Lang:  post.Lang,
+		URL:   absoluteURL("/posts/" + url.PathEscape(post.Slug)),
 	}
+}
 
-	// First, render the content template
-	contentTmpl, err := template.ParseFiles("src/views/posts/list.html")
-	if err != nil {
-		log.Printf("Content template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+// postLang returns the language a post is written in from a post form or API call,
+// the default one when none was picked
+func postLang(r *http.Request, value string) (string, error) {
+	if value == "" {
+		return i18n.DefaultLocale, nil
+	}
+	lang, ok := i18n.Match(value)
+	if !ok {
+		return "", &models.ValidationError{Field: "lang", Message: i18n.T(requestLocale(r), "post_form.invalid_lang")}
+	}
+	return lang, nil
+}
+
+// translationGroupOf returns the translation group of the post with the slug, for
+// another post in group to become its translation. An empty slug has none, and
+// gives 0. Like on the post form, the user can only pick published posts, their
+// own and those already in the group; other drafts are reported as missing.
+func translationGroupOf(r *http.Request, user models.User, slug string, group int) (int, error) {
+	if slug == "" {
+		return 0, nil
 	}
+	notFound := &models.ValidationError{Field: "translation_of", Message: i18n.T(requestLocale(r), "post_form.invalid_translation_of")}
 
-	// Execute content template to a buffer
-	var contentBuffer strings.Builder
-	err = contentTmpl.ExecuteTemplate(&contentBuffer, "post-list", data)
+	post, err := models.GetPostBySlug(slug)
+	if errors.Is(err, models.ErrNotFound) {
+		return 0, notFound
+	}
 	if err != nil {
-		log.Printf("Content template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return 0, err
+	}
+
+	visible := post.Published || post.AuthorID == user.ID || user.Can(models.PermEditAnyPost) ||
+		(group != 0 && post.TranslationGroup == group)
+	if !visible {
+		return 0, notFound
 	}
+	return post.TranslationGroup, nil
+}
 
-	// Add the rendered content to the data
-	data.Content = template.HTML(contentBuffer.String())
+// listLang returns the language /posts is filtered to: the one in the lang query
+// parameter, or the visitor's. It returns allLanguages when asked for every language.
+func listLang(r *http.Request) string {
+	value := r.URL.Query().Get("lang")
+	if value == allLanguages {
+		return allLanguages
+	}
+	if lang, ok := i18n.Match(value); ok {
+		return lang
+	}
+	return requestLocale(r)
+}
 
-	// Parse layout template
-	layoutTmpl, err := template.ParseFiles(
-		"src/views/layout.html",
-		"src/views/home/hero.html",
-		"src/views/home/footer.html",
-		"src/views/home/section.html",
-	)
+// ListPostsHandler handles the GET /posts route
+func ListPostsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the published posts in the language
+	lang := listLang(r)
+	var posts []models.Post
+	var err error
+	if lang == allLanguages {
+		posts, err = models.GetPublishedPosts()
+	} else {
+		posts, err = models.GetPublishedPostsByLang(lang)
+	}
 	if err != nil {
-		log.Printf("Layout template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		handleError(w, r, fmt.Errorf("getting posts: %w", err))
 		return
 	}
 
-	// Execute layout template
-	err = layoutTmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Layout template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Answer with JSON when asked for it, at /posts.json or with an Accept header
+	if negotiateJSON(w, r) {
+		list := make([]postJSON, 0, len(posts))
+		for _, post := range posts {
+			list = append(list, newPostJSON(post))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"posts": list, "lang": lang})
+		return
 	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:    i18n.T(requestLocale(r), "posts.title"),
+		Posts:    posts,
+		PostLang: lang,
+	}
+
+	renderPage(w, r, "posts/list", data)
 }
 
 // ViewPostHandler handles the GET /posts/:slug route
 func ViewPostHandler(w http.ResponseWriter, r *http.Request) {
-	// Get slug from URL
-	slug := chi.URLParam(r, "slug")
+	// Get slug from URL, where a .json suffix asks for JSON
+	slug := strings.TrimSuffix(chi.URLParam(r, "slug"), jsonSuffix)
+	asJSON := negotiateJSON(w, r)
 
 	// Get post by slug
 	post, err := models.GetPostBySlug(slug)
 	if err != nil {
-		log.Printf("Error getting post: %v", err)
-		http.Error(w, "Post not found", http.StatusNotFound)
+		handleError(w, r, fmt.Errorf("getting post: %w", err))
 		return
 	}
 
-	// Convert Markdown to HTML
-	htmlContent := template.HTML(utils.MarkdownToHTML(post.Content))
-
-	// Prepare template data
-	data := TemplateData{
-		Title:       post.Title,
-		Post:        post,
-		HTMLContent: htmlContent,
+	// Drafts are only visible to the users who may edit them
+	viewer, signedIn := middleware.UserFromContext(r.Context())
+	canEdit := signedIn && viewer.CanEditPost(post)
+	if !post.Published && !canEdit {
+		renderErrorPage(w, r, http.StatusNotFound)
+		return
 	}
 
-	// First, render the content template
-	contentTmpl, err := template.ParseFiles("src/views/posts/single.html")
+	// Get the HTML rendered when the post was saved
+	htmlContent := template.HTML(post.ContentHTML())
+
+	// Get related posts
+	relatedPosts, err := models.GetRelatedPosts(post.ID, relatedPostsLimit)
 	if err != nil {
-		log.Printf("Content template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		log.Printf("Error getting related posts: %v", err)
+		// Continue without related posts
+		relatedPosts = nil
 	}
 
-	// Execute content template to a buffer
-	var contentBuffer strings.Builder
-	err = contentTmpl.ExecuteTemplate(&contentBuffer, "single-post", data)
+	// Get the neighbouring posts for navigation
+	previousPost, err := models.GetPreviousPost(post)
 	if err != nil {
-		log.Printf("Content template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		log.Printf("Error getting previous post: %v", err)
+	}
+	nextPost, err := models.GetNextPost(post)
+	if err != nil {
+		log.Printf("Error getting next post: %v", err)
 	}
 
-	// Add the rendered content to the data
-	data.Content = template.HTML(contentBuffer.String())
-
-	// Parse layout template
-	layoutTmpl, err := template.ParseFiles(
-		"src/views/layout.html",
-		"src/views/home/hero.html",
-		"src/views/home/footer.html",
-		"src/views/home/section.html",
-	)
+	// Get the post in other languages
+	translations, err := models.GetPublishedTranslations(post)
 	if err != nil {
-		log.Printf("Layout template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		log.Printf("Error getting translations: %v", err)
+	}
+
+	if asJSON {
+		related := make([]postLinkJSON, 0, len(relatedPosts))
+		for _, relatedPost := range relatedPosts {
+			related = append(related, *newPostLinkJSON(relatedPost))
+		}
+		translated := make([]postLinkJSON, 0, len(translations))
+		for _, translation := range translations {
+			translated = append(translated, *newPostLinkJSON(translation))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"post":         newPostJSON(post),
+			"previous":     newPostLinkJSON(previousPost),
+			"next":         newPostLinkJSON(nextPost),
+			"related":      related,
+			"translations": translated,
+		})
 		return
 	}
 
-	// Execute layout template
-	err = layoutTmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Layout template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Describe the post for search engines and link previews
+	meta := postMeta(post, string(htmlContent))
+	meta.Alternates = translationAlternates(post, translations)
+	meta.StructuredData = []any{postData(post, string(htmlContent), meta)}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:        post.Title,
+		Post:         post,
+		PreviousPost: previousPost,
+		NextPost:     nextPost,
+		RelatedPosts: relatedPosts,
+		HTMLContent:  htmlContent,
+		CanEditPost:  canEdit,
+		Meta:         meta,
+		Translations: translations,
 	}
+
+	renderPage(w, r, "posts/single", data)
 }
 
 // HomeHandler handles the GET / route
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	// Get recent posts (limit to 3)
-	posts, err := models.GetAllPosts()
+	posts, err := models.GetPublishedPosts()
 	if err != nil {
 		log.Printf("Error getting posts: %v", err)
 		// Continue without posts
@@ -159,36 +350,13 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		posts = posts[:3]
 	}
 
-	// Check if user is admin
-	_, isAdmin := r.Context().Value("username").(string)
-
 	// Prepare template data
 	data := TemplateData{
-		Title:   "Chewawi",
-		Posts:   posts,
-		IsAdmin: isAdmin,
-	}
-
-	// For the home page, we don't pre-render a content template
-	// The layout will use the default "section" template
-
-	// Parse layout template
-	layoutTmpl, err := template.ParseFiles(
-		"src/views/layout.html",
-		"src/views/home/hero.html",
-		"src/views/home/footer.html",
-		"src/views/home/section.html",
-	)
-	if err != nil {
-		log.Printf("Layout template parsing error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		Title: "Chewawi",
+		Posts: posts,
+		Meta:  PageMeta{StructuredData: homeData(posts)},
 	}
 
-	// Execute layout template
-	err = layoutTmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Layout template execution error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-	}
+	// The home page shows the hero and the latest posts
+	renderPage(w, r, "home/index", data)
 }
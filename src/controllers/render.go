@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/views"
+)
+
+// renderPage renders the named page, such as "admin/login", inside the layout
+func renderPage(w http.ResponseWriter, r *http.Request, page string, data TemplateData) {
+	renderPageStatus(w, r, http.StatusOK, page, data)
+}
+
+// renderPageStatus renders a page like renderPage, answering with the given status code
+func renderPageStatus(w http.ResponseWriter, r *http.Request, status int, page string, data TemplateData) {
+	// Let forms send back the CSRF token, and show signed-in users the admin links
+	data.CSRFToken = middleware.CSRFToken(r)
+	data.CurrentUser = signedInUser(r)
+	data.Lang = requestLocale(r)
+	data.Locales = i18n.Locales()
+	data.RequestURI = r.URL.RequestURI()
+	fillPageMeta(r, &data)
+
+	// Render to a buffer so the status is only sent on success
+	var pageBuffer bytes.Buffer
+	err := views.Page(&pageBuffer, page, data)
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	pageBuffer.WriteTo(w)
+}
+
+// signedInUser returns the user who made the request for the layout, or nil for visitors
+func signedInUser(r *http.Request) *middleware.CurrentUser {
+	current, ok := middleware.CurrentUserFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	return &current
+}
+
+// requestLocale returns the locale picked for the request
+func requestLocale(r *http.Request) string {
+	return middleware.LocaleFromContext(r.Context())
+}
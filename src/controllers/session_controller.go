@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// SessionsHandler handles the GET /owner/sessions route
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Get the user's active sessions
+	sessions, err := models.GetSessionsByUser(user.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting sessions: %w", err))
+		return
+	}
+
+	current, _ := middleware.SessionFromContext(r.Context())
+
+	// Prepare template data
+	data := TemplateData{
+		Title:          i18n.T(requestLocale(r), "sessions.title"),
+		User:           user,
+		Sessions:       sessions,
+		CurrentSession: current.ID,
+	}
+
+	renderPage(w, r, "admin/sessions", data)
+}
+
+// RevokeSessionHandler handles the POST /owner/sessions/:id/revoke route
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	// Get session ID from URL
+	id := chi.URLParam(r, "id")
+
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Revoke session
+	err = models.DeleteUserSession(id, user.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("revoking session: %w", err))
+		return
+	}
+
+	// Revoking this browser's own session signs it out
+	if current, ok := middleware.SessionFromContext(r.Context()); ok && current.ID == id {
+		middleware.ClearSessionCookies(w)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Redirect to the sessions list
+	http.Redirect(w, r, "/owner/sessions", http.StatusSeeOther)
+}
+
+// RevokeOtherSessionsHandler handles the POST /owner/sessions/revoke-others route
+func RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Revoke every session but this one
+	current, _ := middleware.SessionFromContext(r.Context())
+	err = models.DeleteUserSessions(user.ID, current.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("revoking sessions: %w", err))
+		return
+	}
+
+	// Redirect to the sessions list
+	http.Redirect(w, r, "/owner/sessions", http.StatusSeeOther)
+}
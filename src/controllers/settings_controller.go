@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/models"
+)
+
+// SettingsHandler handles the GET /owner/settings route
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get current settings
+	requireAdmin2FA, err := models.GetBoolSetting(models.SettingRequireAdmin2FA)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting settings: %w", err))
+		return
+	}
+
+	user, _ := currentUser(r)
+
+	// Prepare template data
+	data := TemplateData{
+		Title:           i18n.T(requestLocale(r), "settings.title"),
+		User:            user,
+		RequireAdmin2FA: requireAdmin2FA,
+	}
+
+	renderPage(w, r, "admin/settings", data)
+}
+
+// UpdateSettingsHandler handles the POST /owner/settings route
+func UpdateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Store settings
+	requireAdmin2FA := "false"
+	if r.FormValue("require_admin_2fa") == "on" {
+		requireAdmin2FA = "true"
+	}
+
+	err = models.SetSetting(models.SettingRequireAdmin2FA, requireAdmin2FA)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("saving settings: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, "/owner/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/url"
+	"time"
+
+	"chewawi_web/src/models"
+	"chewawi_web/src/utils"
+)
+
+// schemaContext is the vocabulary of the structured data, see https://schema.org
+const schemaContext = "https://schema.org"
+
+// schemaPerson is a schema.org Person
+type schemaPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+// schemaBlogPosting is a schema.org BlogPosting, for a post's page or a post listed in a blog
+type schemaBlogPosting struct {
+	Context          string        `json:"@context,omitempty"`
+	Type             string        `json:"@type"`
+	Headline         string        `json:"headline"`
+	Description      string        `json:"description,omitempty"`
+	URL              string        `json:"url"`
+	MainEntityOfPage string        `json:"mainEntityOfPage,omitempty"`
+	DatePublished    string        `json:"datePublished"`
+	DateModified     string        `json:"dateModified,omitempty"`
+	Author           *schemaPerson `json:"author,omitempty"`
+	Image            []string      `json:"image,omitempty"`
+	WordCount        int           `json:"wordCount,omitempty"`
+	InLanguage       string        `json:"inLanguage,omitempty"`
+}
+
+// schemaBlog is a schema.org Blog listing its latest posts
+type schemaBlog struct {
+	Context     string              `json:"@context"`
+	Type        string              `json:"@type"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	URL         string              `json:"url"`
+	BlogPost    []schemaBlogPosting `json:"blogPost,omitempty"`
+}
+
+// schemaWebSite is a schema.org WebSite
+type schemaWebSite struct {
+	Context string `json:"@context"`
+	Type    string `json:"@type"`
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+}
+
+// schemaBreadcrumbList is a schema.org BreadcrumbList
+type schemaBreadcrumbList struct {
+	Context         string           `json:"@context"`
+	Type            string           `json:"@type"`
+	ItemListElement []schemaListItem `json:"itemListElement"`
+}
+
+// schemaListItem is an entry of a schema.org BreadcrumbList
+type schemaListItem struct {
+	Type     string `json:"@type"`
+	Position int    `json:"position"`
+	Name     string `json:"name"`
+	Item     string `json:"item"`
+}
+
+// JSONLD returns the page's structured data, one script body per object. json.Marshal
+// escapes <, > and &, so no value can close the script tag it's placed in.
+func (m PageMeta) JSONLD() ([]template.JS, error) {
+	scripts := make([]template.JS, 0, len(m.StructuredData))
+	for _, object := range m.StructuredData {
+		encoded, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, template.JS(encoded))
+	}
+	return scripts, nil
+}
+
+// breadcrumbData describes the breadcrumbs the layout shows above every page but the home page
+func breadcrumbData(home, title, pageURL string) schemaBreadcrumbList {
+	return schemaBreadcrumbList{
+		Context: schemaContext,
+		Type:    "BreadcrumbList",
+		ItemListElement: []schemaListItem{
+			{Type: "ListItem", Position: 1, Name: home, Item: absoluteURL("/")},
+			{Type: "ListItem", Position: 2, Name: title, Item: pageURL},
+		},
+	}
+}
+
+// postData describes a post for its own page
+func postData(post models.Post, renderedHTML string, meta PageMeta) schemaBlogPosting {
+	posting := listedPostData(post)
+	posting.Context = schemaContext
+	posting.Description = meta.Description
+	posting.MainEntityOfPage = meta.CanonicalURL
+	posting.DateModified = schemaTime(post.Updated)
+	posting.WordCount = utils.WordCount(renderedHTML)
+	if meta.Image != "" {
+		posting.Image = []string{absoluteURL(meta.Image)}
+	}
+	return posting
+}
+
+// listedPostData describes a post as listed on the blog
+func listedPostData(post models.Post) schemaBlogPosting {
+	return schemaBlogPosting{
+		Type:          "BlogPosting",
+		Headline:      post.Title,
+		URL:           absoluteURL("/posts/" + url.PathEscape(post.Slug)),
+		DatePublished: schemaTime(post.Created),
+		InLanguage:    post.Lang,
+		Author: &schemaPerson{
+			Type: "Person",
+			Name: post.Author.Name(),
+			URL:  absoluteURL("/authors/" + url.PathEscape(post.Author.Username)),
+		},
+	}
+}
+
+// homeData describes the site and the blog with its latest posts for the home page
+func homeData(posts []models.Post) []any {
+	site := schemaWebSite{
+		Context: schemaContext,
+		Type:    "WebSite",
+		Name:    siteName,
+		URL:     absoluteURL("/"),
+	}
+
+	blog := schemaBlog{
+		Context:     schemaContext,
+		Type:        "Blog",
+		Name:        siteName,
+		Description: siteDescription,
+		URL:         absoluteURL("/posts"),
+	}
+	for _, post := range posts {
+		blog.BlogPost = append(blog.BlogPost, listedPostData(post))
+	}
+
+	return []any{site, blog}
+}
+
+// schemaTime formats a time as ISO 8601 like schema.org expects
+func schemaTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
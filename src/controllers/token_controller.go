@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// tokenExpiryDays are the lifetimes offered for new API tokens, 0 meaning no expiry
+var tokenExpiryDays = []int{30, 90, 365, 0}
+
+// APITokensHandler handles the GET /owner/tokens route
+func APITokensHandler(w http.ResponseWriter, r *http.Request) {
+	renderAPITokensPage(w, r, "", "")
+}
+
+// CreateAPITokenHandler handles the POST /owner/tokens route
+func CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Get form values
+	name := r.FormValue("name")
+	var scopes []models.Scope
+	for _, value := range r.Form["scopes"] {
+		scope := models.Scope(value)
+		if !scope.Valid() {
+			renderAPITokensPage(w, r, "", i18n.T(requestLocale(r), "tokens.unknown_scope", value))
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	expiresInDays, err := strconv.Atoi(r.FormValue("expires"))
+	if err != nil || expiresInDays < 0 {
+		expiresInDays = tokenExpiryDays[0]
+	}
+
+	// Validate form
+	if name == "" || len(scopes) == 0 {
+		renderAPITokensPage(w, r, "", i18n.T(requestLocale(r), "tokens.required"))
+		return
+	}
+
+	// Create token
+	token, prefix, hash := middleware.GenerateAPIToken()
+	err = models.CreateAPIToken(user.ID, name, prefix, hash, scopes, expiresInDays)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("creating API token: %w", err))
+		return
+	}
+
+	// Show the token once; only its hash is kept
+	w.Header().Set("Cache-Control", "no-store")
+	renderAPITokensPage(w, r, token, "")
+}
+
+// DeleteAPITokenHandler handles the POST /owner/tokens/:id/delete route
+func DeleteAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Get token ID from URL
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		renderErrorPage(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Revoke token
+	err = models.DeleteAPIToken(id, user.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("deleting API token: %w", err))
+		return
+	}
+
+	// Redirect to the tokens list
+	http.Redirect(w, r, "/owner/tokens", http.StatusSeeOther)
+}
+
+// renderAPITokensPage renders the API tokens page, with a just created token or an error
+func renderAPITokensPage(w http.ResponseWriter, r *http.Request, newToken, errorMessage string) {
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Get the user's tokens
+	tokens, err := models.GetAPITokensByUser(user.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting API tokens: %w", err))
+		return
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:           i18n.T(requestLocale(r), "tokens.title"),
+		User:            user,
+		Error:           errorMessage,
+		APITokens:       tokens,
+		NewAPIToken:     newToken,
+		Scopes:          models.Scopes,
+		TokenExpiryDays: tokenExpiryDays,
+	}
+
+	renderPage(w, r, "admin/tokens", data)
+}
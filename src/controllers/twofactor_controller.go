@@ -0,0 +1,356 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// totpIssuer is the account issuer shown in authenticator apps
+const totpIssuer = "Chewawi"
+
+// recoveryCodeCount is how many recovery codes are generated when enabling 2FA
+const recoveryCodeCount = 10
+
+// twoFactorCookie holds the pending login between the password and the code steps
+const twoFactorCookie = "2fa"
+
+// startTwoFactorLogin remembers a user who passed the password step and asks for their code
+func startTwoFactorLogin(w http.ResponseWriter, r *http.Request, user models.User, options middleware.LoginOptions) {
+	token, err := middleware.GeneratePendingTwoFactorToken(user.Username, options)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("generating token: %w", err))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     twoFactorCookie,
+		Value:    token,
+		Path:     "/login",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+// pendingTwoFactorUser returns the user who passed the password step of the login
+// and the choices they made on the login page
+func pendingTwoFactorUser(r *http.Request) (models.User, middleware.LoginOptions, bool) {
+	cookie, err := r.Cookie(twoFactorCookie)
+	if err != nil {
+		return models.User{}, middleware.LoginOptions{}, false
+	}
+
+	username, options, err := middleware.ParsePendingTwoFactorToken(cookie.Value)
+	if err != nil {
+		return models.User{}, middleware.LoginOptions{}, false
+	}
+
+	user, err := models.GetUserByUsername(username)
+	if err != nil || !user.TOTPEnabled {
+		return models.User{}, middleware.LoginOptions{}, false
+	}
+	return user, options, true
+}
+
+// LoginTwoFactorHandler handles the GET /login/2fa route
+func LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := pendingTwoFactorUser(r); !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title: i18n.T(requestLocale(r), "two_factor.title"),
+	}
+
+	renderPage(w, r, "admin/login_2fa", data)
+}
+
+// LoginTwoFactorSubmitHandler handles the POST /login/2fa route
+func LoginTwoFactorSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	user, options, ok := pendingTwoFactorUser(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Guessing codes counts towards the same limits as guessing passwords
+	ip := middleware.ClientIP(r)
+	if !checkLoginThrottle(w, r, user.Username, ip, "admin/login_2fa", i18n.T(requestLocale(r), "two_factor.title")) {
+		return
+	}
+
+	// Accept either a TOTP code or a recovery code
+	code := r.FormValue("code")
+	valid := verifyTOTPCode(user, code)
+	if !valid && strings.Contains(code, "-") {
+		valid, err = models.UseRecoveryCode(user.ID, middleware.HashRecoveryCode(code))
+		if err != nil {
+			log.Printf("Error checking recovery code: %v", err)
+		}
+	}
+
+	if !valid {
+		middleware.RecordLoginResult(user.Username, ip, false)
+
+		// Prepare template data
+		data := TemplateData{
+			Title: i18n.T(requestLocale(r), "two_factor.title"),
+			Error: i18n.T(requestLocale(r), "two_factor.invalid_code"),
+		}
+
+		renderPage(w, r, "admin/login_2fa", data)
+		return
+	}
+
+	// Clear the pending login
+	http.SetCookie(w, &http.Cookie{
+		Name:     twoFactorCookie,
+		Value:    "",
+		Path:     "/login",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		HttpOnly: true,
+	})
+
+	signIn(w, r, user, options)
+}
+
+// verifyTOTPCode checks a TOTP code for the user, refusing codes that were already used
+func verifyTOTPCode(user models.User, code string) bool {
+	secret, err := models.GetTOTPSecret(user.ID)
+	if err != nil || secret == "" {
+		return false
+	}
+
+	step, ok := middleware.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	fresh, err := models.UseTOTPStep(user.ID, step)
+	if err != nil {
+		log.Printf("Error recording TOTP step: %v", err)
+		return false
+	}
+	return fresh
+}
+
+// TwoFactorHandler handles the GET /owner/2fa route
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	renderTwoFactorPage(w, r, "", nil)
+}
+
+// SetupTwoFactorHandler handles the POST /owner/2fa/setup route
+func SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Re-enrolling replaces the current secret, so require disabling first
+	if user.TOTPEnabled {
+		http.Redirect(w, r, "/owner/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Generate a secret to be confirmed with a first code
+	secret, err := middleware.GenerateTOTPSecret()
+	if err != nil {
+		handleError(w, r, fmt.Errorf("generating TOTP secret: %w", err))
+		return
+	}
+
+	err = models.SetPendingTOTPSecret(user.ID, secret)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("storing TOTP secret: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, "/owner/2fa", http.StatusSeeOther)
+}
+
+// EnableTwoFactorHandler handles the POST /owner/2fa/enable route
+func EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// The first code proves the authenticator app was set up correctly
+	if user.TOTPEnabled || !verifyTOTPCode(user, r.FormValue("code")) {
+		renderTwoFactorPage(w, r, i18n.T(requestLocale(r), "two_factor.invalid_setup_code"), nil)
+		return
+	}
+
+	// Generate recovery codes, which are only shown this once
+	codes, err := middleware.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("generating recovery codes: %w", err))
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = middleware.HashRecoveryCode(code)
+	}
+
+	err = models.EnableTOTP(user.ID, hashes)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("enabling TOTP: %w", err))
+		return
+	}
+
+	renderTwoFactorPage(w, r, "", codes)
+}
+
+// DisableTwoFactorHandler handles the POST /owner/2fa/disable route
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get the signed-in user
+	user, err := currentUser(r)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Admins can't opt out while two-factor is required
+	required, err := twoFactorRequired(user)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting settings: %w", err))
+		return
+	}
+	if required && user.TOTPEnabled {
+		renderTwoFactorPage(w, r, i18n.T(requestLocale(r), "two_factor.required"), nil)
+		return
+	}
+
+	// Ask for a current code so a hijacked session can't turn it off
+	if user.TOTPEnabled && !verifyTOTPCode(user, r.FormValue("code")) {
+		renderTwoFactorPage(w, r, i18n.T(requestLocale(r), "two_factor.invalid_code"), nil)
+		return
+	}
+
+	err = models.DisableTOTP(user.ID)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("disabling TOTP: %w", err))
+		return
+	}
+
+	http.Redirect(w, r, "/owner/2fa", http.StatusSeeOther)
+}
+
+// renderTwoFactorPage renders the two-factor settings page, with freshly
+// generated recovery codes if there are any to show
+func renderTwoFactorPage(w http.ResponseWriter, r *http.Request, errorMessage string, recoveryCodes []string) {
+	// Get the signed-in user, reloaded so the page reflects any change just made
+	user, err := currentUser(r)
+	if err == nil {
+		user, err = models.GetUserByUsername(user.Username)
+	}
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting current user: %w", err))
+		return
+	}
+
+	// Prepare template data
+	data := TemplateData{
+		Title:         i18n.T(requestLocale(r), "two_factor.title"),
+		Error:         errorMessage,
+		User:          user,
+		RecoveryCodes: recoveryCodes,
+	}
+
+	if user.TOTPEnabled {
+		data.RecoveryCodesLeft, err = models.CountRecoveryCodes(user.ID)
+		if err != nil {
+			log.Printf("Error counting recovery codes: %v", err)
+		}
+	} else {
+		// Show the QR code of a secret that still has to be confirmed
+		secret, err := models.GetTOTPSecret(user.ID)
+		if err != nil {
+			handleError(w, r, fmt.Errorf("getting TOTP secret: %w", err))
+			return
+		}
+
+		if secret != "" {
+			png, err := qrcode.Encode(middleware.TOTPURL(totpIssuer, user.Username, secret), qrcode.Medium, 256)
+			if err != nil {
+				handleError(w, r, fmt.Errorf("generating QR code: %w", err))
+				return
+			}
+			data.TOTPSecret = secret
+			data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		}
+	}
+
+	renderPage(w, r, "admin/two_factor", data)
+}
+
+// twoFactorRequired reports whether the user must have two-factor authentication enabled
+func twoFactorRequired(user models.User) (bool, error) {
+	if user.Role != models.RoleAdmin {
+		return false, nil
+	}
+	return models.GetBoolSetting(models.SettingRequireAdmin2FA)
+}
+
+// RequireTwoFactorEnrollment is a middleware that sends admins without two-factor
+// authentication to the enrollment page when the settings require it
+func RequireTwoFactorEnrollment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r)
+		if err == nil && !user.TOTPEnabled {
+			required, err := twoFactorRequired(user)
+			if err != nil {
+				log.Printf("Error getting settings: %v", err)
+			}
+			if required {
+				http.Redirect(w, r, "/owner/2fa", http.StatusSeeOther)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListUsersHandler handles the GET /owner/users route
+func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	renderUsersPage(w, r, "")
+}
+
+// CreateUserHandler handles the POST /owner/users route
+func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get form values
+	username := r.FormValue("username")
+	email := strings.TrimSpace(r.FormValue("email"))
+	password := r.FormValue("password")
+	role := models.Role(r.FormValue("role"))
+
+	// Validate form; without a password the user is invited by email to choose one
+	if username == "" || !role.Valid() || (password == "" && email == "") {
+		renderUsersPage(w, r, i18n.T(requestLocale(r), "users.required"))
+		return
+	}
+	if email != "" && !validEmail(email) {
+		renderUsersPage(w, r, i18n.T(requestLocale(r), "admin.invalid_email"))
+		return
+	}
+
+	// Hash the initial password
+	var hash string
+	if password != "" {
+		hash, err = middleware.HashPassword(password)
+		if err != nil {
+			handleError(w, r, fmt.Errorf("hashing password: %w", err))
+			return
+		}
+	}
+
+	// Create user, with the email address and password in the same step
+	user, err := models.CreateUserAccount(username, email, hash, role)
+	var conflict *models.ConflictError
+	if errors.As(err, &conflict) && conflict.Field == "email" {
+		renderUsersPage(w, r, i18n.T(requestLocale(r), "users.email_taken"))
+		return
+	}
+	if errors.Is(err, models.ErrConflict) {
+		renderUsersPage(w, r, i18n.T(requestLocale(r), "users.username_taken"))
+		return
+	}
+	if err != nil {
+		handleError(w, r, fmt.Errorf("creating user: %w", err))
+		return
+	}
+
+	// Without the invitation the user couldn't sign in, so take them back out and
+	// let the admin try again
+	if hash == "" {
+		inviter, _ := currentUser(r)
+		if err := sendUserTokenMail(requestLocale(r), user, inviter, models.TokenInvite); err != nil {
+			log.Printf("Error sending invitation to %s: %v", user.Username, err)
+			if err := models.DeleteUser(user.ID); err != nil {
+				log.Printf("Error deleting uninvited user %s: %v", user.Username, err)
+			}
+			renderUsersPage(w, r, i18n.T(requestLocale(r), "users.invite_failed"))
+			return
+		}
+	}
+
+	// Redirect to the users list
+	http.Redirect(w, r, "/owner/users", http.StatusSeeOther)
+}
+
+// UpdateUserRoleHandler handles the POST /owner/users/:username/role route
+func UpdateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	// Get username from URL
+	username := chi.URLParam(r, "username")
+
+	// Parse form
+	err := r.ParseForm()
+	if err != nil {
+		log.Printf("Form parsing error: %v", err)
+		renderErrorPage(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Get user by username
+	user, err := models.GetUserByUsername(username)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting user: %w", err))
+		return
+	}
+
+	// Admins can't demote themselves, so there is always one left
+	self, err := currentUser(r)
+	if err == nil && self.ID == user.ID {
+		renderUsersPage(w, r, i18n.T(requestLocale(r), "users.own_role"))
+		return
+	}
+
+	// Update role
+	_, err = models.UpdateUserRole(user.ID, models.Role(r.FormValue("role")))
+	if errors.Is(err, models.ErrValidation) {
+		renderUsersPage(w, r, i18n.T(requestLocale(r), "users.invalid_role"))
+		return
+	}
+	if err != nil {
+		handleError(w, r, fmt.Errorf("updating role: %w", err))
+		return
+	}
+
+	// Redirect to the users list
+	http.Redirect(w, r, "/owner/users", http.StatusSeeOther)
+}
+
+// loginAttemptsLimit is how many failed sign-in attempts are listed
+const loginAttemptsLimit = 100
+
+// LoginAttemptsHandler handles the GET /owner/login-attempts route
+func LoginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the latest failed attempts
+	attempts, err := models.GetRecentLoginFailures(loginAttemptsLimit)
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting login attempts: %w", err))
+		return
+	}
+
+	user, _ := currentUser(r)
+
+	// Prepare template data
+	data := TemplateData{
+		Title:         i18n.T(requestLocale(r), "attempts.title"),
+		User:          user,
+		LoginAttempts: attempts,
+	}
+
+	renderPage(w, r, "admin/login_attempts", data)
+}
+
+// renderUsersPage renders the user management page with an optional error
+func renderUsersPage(w http.ResponseWriter, r *http.Request, errorMessage string) {
+	// Get all users
+	users, err := models.GetAllUsers()
+	if err != nil {
+		handleError(w, r, fmt.Errorf("getting users: %w", err))
+		return
+	}
+
+	user, _ := currentUser(r)
+
+	// Prepare template data
+	data := TemplateData{
+		Title: i18n.T(requestLocale(r), "users.title"),
+		Error: errorMessage,
+		Users: users,
+		Roles: models.Roles,
+		User:  user,
+	}
+
+	renderPage(w, r, "admin/users", data)
+}
+
+// validEmail reports whether the address is a bare email address like me@example.com
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
@@ -58,6 +58,302 @@ func createTables() {
 		log.Fatalf("Failed to create posts table: %v", err)
 	}
 
+	// Index posts by date for listing and previous/next lookups
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS posts_created_idx ON posts (created, id)`)
+	if err != nil {
+		log.Fatalf("Failed to create posts created index: %v", err)
+	}
+
+	// Create users table
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id SERIAL PRIMARY KEY,
+			username VARCHAR(64) NOT NULL UNIQUE,
+			display_name VARCHAR(255) NOT NULL DEFAULT '',
+			bio TEXT NOT NULL DEFAULT '',
+			avatar_url VARCHAR(512) NOT NULL DEFAULT '',
+			created TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create users table: %v", err)
+	}
+
+	// Store password hashes on users
+	_, err = DB.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash VARCHAR(255) NOT NULL DEFAULT ''`)
+	if err != nil {
+		log.Fatalf("Failed to add users password column: %v", err)
+	}
+
+	// Give every user a role, authors by default
+	_, err = DB.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'author'`)
+	if err != nil {
+		log.Fatalf("Failed to add users role column: %v", err)
+	}
+
+	// Store TOTP two-factor state on users
+	_, err = DB.Exec(`
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64) NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add users TOTP columns: %v", err)
+	}
+
+	// Link users to their OpenID Connect identity
+	_, err = DB.Exec(`
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS oidc_issuer VARCHAR(255),
+			ADD COLUMN IF NOT EXISTS oidc_subject VARCHAR(255)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add users OIDC columns: %v", err)
+	}
+
+	_, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_idx ON users (oidc_issuer, oidc_subject)`)
+	if err != nil {
+		log.Fatalf("Failed to create users OIDC index: %v", err)
+	}
+
+	// Store email addresses on users for password resets and invitations
+	_, err = DB.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(255) NOT NULL DEFAULT ''`)
+	if err != nil {
+		log.Fatalf("Failed to add users email column: %v", err)
+	}
+
+	_, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (LOWER(email)) WHERE email <> ''`)
+	if err != nil {
+		log.Fatalf("Failed to create users email index: %v", err)
+	}
+
+	// Create recovery codes table
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash CHAR(64) NOT NULL,
+			used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create recovery codes table: %v", err)
+	}
+
+	// Create passkeys table
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS passkeys (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL DEFAULT '',
+			credential_id BYTEA NOT NULL UNIQUE,
+			data JSONB NOT NULL,
+			sign_count BIGINT NOT NULL DEFAULT 0,
+			created TIMESTAMP NOT NULL DEFAULT NOW(),
+			last_used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create passkeys table: %v", err)
+	}
+
+	// Create sessions table
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id VARCHAR(64) PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			user_agent VARCHAR(512) NOT NULL DEFAULT '',
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			created TIMESTAMP NOT NULL DEFAULT NOW(),
+			last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create sessions table: %v", err)
+	}
+
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id)`)
+	if err != nil {
+		log.Fatalf("Failed to create sessions user index: %v", err)
+	}
+
+	// Store rotating refresh tokens on sessions
+	_, err = DB.Exec(`
+		ALTER TABLE sessions
+			ADD COLUMN IF NOT EXISTS refresh_hash CHAR(64) NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS previous_refresh_hash CHAR(64) NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS refreshed_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS remember BOOLEAN NOT NULL DEFAULT FALSE
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add sessions refresh token columns: %v", err)
+	}
+
+	// Create personal API tokens table
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL DEFAULT '',
+			prefix VARCHAR(16) NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			created TIMESTAMP NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			last_used_ip VARCHAR(45) NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create API tokens table: %v", err)
+	}
+
+	// Create single-use user tokens table, for password resets and invitations
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS user_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose VARCHAR(32) NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			created TIMESTAMP NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create user tokens table: %v", err)
+	}
+
+	// Create login attempts table
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			id SERIAL PRIMARY KEY,
+			username VARCHAR(255) NOT NULL,
+			ip VARCHAR(45) NOT NULL,
+			succeeded BOOLEAN NOT NULL,
+			created TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create login attempts table: %v", err)
+	}
+
+	_, err = DB.Exec(`
+		CREATE INDEX IF NOT EXISTS login_attempts_username_idx ON login_attempts (username, created);
+		CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created);
+		CREATE INDEX IF NOT EXISTS login_attempts_created_idx ON login_attempts (created)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create login attempts indexes: %v", err)
+	}
+
+	// Create settings table
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS settings (
+			key VARCHAR(64) PRIMARY KEY,
+			value TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create settings table: %v", err)
+	}
+
+	// Let posts be kept as unpublished drafts; existing posts stay published
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS published BOOLEAN NOT NULL DEFAULT TRUE`)
+	if err != nil {
+		log.Fatalf("Failed to add posts published column: %v", err)
+	}
+
+	// Link posts to their author
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS author_id INTEGER REFERENCES users(id)`)
+	if err != nil {
+		log.Fatalf("Failed to add posts author column: %v", err)
+	}
+
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS posts_author_idx ON posts (author_id)`)
+	if err != nil {
+		log.Fatalf("Failed to create posts author index: %v", err)
+	}
+
+	// Let posts carry a summary for listings and link previews
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS summary TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		log.Fatalf("Failed to add posts summary column: %v", err)
+	}
+
+	// Note when posts were last edited; posts never edited since have none
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS updated TIMESTAMP`)
+	if err != nil {
+		log.Fatalf("Failed to add posts updated column: %v", err)
+	}
+
+	// Note the language each post is written in; existing posts are in English
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS lang VARCHAR(8) NOT NULL DEFAULT 'en'`)
+	if err != nil {
+		log.Fatalf("Failed to add posts lang column: %v", err)
+	}
+
+	// Group the translations of a post; every post starts in a group of its own
+	_, err = DB.Exec(`CREATE SEQUENCE IF NOT EXISTS post_translation_groups`)
+	if err != nil {
+		log.Fatalf("Failed to create post translation groups sequence: %v", err)
+	}
+
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS translation_group INTEGER NOT NULL DEFAULT nextval('post_translation_groups')`)
+	if err != nil {
+		log.Fatalf("Failed to add posts translation group column: %v", err)
+	}
+
+	// A post has at most one translation per language
+	_, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS posts_translation_lang_idx ON posts (translation_group, lang)`)
+	if err != nil {
+		log.Fatalf("Failed to create posts translation index: %v", err)
+	}
+
+	// Index posts by language for the filtered listing
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS posts_lang_idx ON posts (lang, created)`)
+	if err != nil {
+		log.Fatalf("Failed to create posts lang index: %v", err)
+	}
+
+	// Keep the HTML of posts rendered when they are saved, and the renderer version
+	// that made it; existing posts have none and get rendered when next viewed
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS rendered_html TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		log.Fatalf("Failed to add posts rendered HTML column: %v", err)
+	}
+
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS render_version TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		log.Fatalf("Failed to add posts render version column: %v", err)
+	}
+
+	// Let posts be tagged, so related posts can be found by the tags they share
+	_, err = DB.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}'`)
+	if err != nil {
+		log.Fatalf("Failed to add posts tags column: %v", err)
+	}
+
+	// Make sure the env-configured admin has an account and owns any post without an author
+	adminUser := getEnv("ADMIN_USER", "admin")
+	_, err = DB.Exec(`INSERT INTO users (username, display_name, role) VALUES ($1, $1, 'admin') ON CONFLICT (username) DO NOTHING`, adminUser)
+	if err != nil {
+		log.Fatalf("Failed to create admin user: %v", err)
+	}
+
+	// Promote the env-configured admin if nobody is an admin yet
+	_, err = DB.Exec(`UPDATE users SET role = 'admin' WHERE username = $1 AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')`, adminUser)
+	if err != nil {
+		log.Fatalf("Failed to promote admin user: %v", err)
+	}
+
+	_, err = DB.Exec(`UPDATE posts SET author_id = (SELECT id FROM users WHERE username = $1) WHERE author_id IS NULL`, adminUser)
+	if err != nil {
+		log.Fatalf("Failed to assign posts to admin user: %v", err)
+	}
+
 	log.Println("Tables created successfully")
 }
 
@@ -0,0 +1,288 @@
+// Package i18n translates the interface and formats dates for each locale.
+//
+// Each locale has a catalog in locales/<code>.json holding its name, its date
+// formats and its messages. A message is either a string or, when it depends on a
+// count, an object with a form per plural category such as "one" and "other".
+// Messages are fmt format strings; plural messages get the count as their first
+// argument. Messages missing from a catalog fall back to the default locale's.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is used when nothing better matches the visitor
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// Locale describes a locale the site is translated to
+type Locale struct {
+	// Code is the language code, such as "es"
+	Code string
+	// Name is the locale's name in its own language, such as "Español"
+	Name string
+}
+
+// catalog is the content of a locale's file
+type catalog struct {
+	Name     string             `json:"name"`
+	Dates    dateFormats        `json:"dates"`
+	Messages map[string]message `json:"messages"`
+}
+
+// dateFormats spell out dates with placeholders: {month} and {mon} for the full and
+// short month name, {day} and {dd} for the day without and with padding, {year},
+// {hh} and {mm} for the hour and minute
+type dateFormats struct {
+	Months      []string `json:"months"`
+	ShortMonths []string `json:"short_months"`
+	Date        string   `json:"date"`
+	ShortDate   string   `json:"short_date"`
+	DateTime    string   `json:"date_time"`
+}
+
+// message holds the forms of a message, keyed by plural category. A message that
+// doesn't depend on a count only has "other".
+type message map[string]string
+
+// UnmarshalJSON reads a message from a string or an object of plural forms
+func (m *message) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = message{"other": text}
+		return nil
+	}
+
+	var forms map[string]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return errors.New("a message must be a string or an object of plural forms")
+	}
+	if forms["other"] == "" {
+		return errors.New("a plural message needs an \"other\" form")
+	}
+	*m = forms
+	return nil
+}
+
+// catalogs holds every locale's catalog by code, see Load
+var catalogs = map[string]catalog{}
+
+// Load reads the catalog of every locale
+func Load() error {
+	files, err := fs.Glob(localeFS, "locales/*.json")
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]catalog)
+	for _, file := range files {
+		data, err := localeFS.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var c catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("i18n: %s: %w", file, err)
+		}
+		if len(c.Dates.Months) != 12 || len(c.Dates.ShortMonths) != 12 {
+			return fmt.Errorf("i18n: %s: months and short_months need 12 names each", file)
+		}
+		loaded[strings.TrimSuffix(path.Base(file), ".json")] = c
+	}
+
+	if _, ok := loaded[DefaultLocale]; !ok {
+		return fmt.Errorf("i18n: no catalog for the default locale %q", DefaultLocale)
+	}
+	catalogs = loaded
+	return nil
+}
+
+// Locales returns the locales the site is translated to, the default one first
+func Locales() []Locale {
+	locales := make([]Locale, 0, len(catalogs))
+	for code, c := range catalogs {
+		locales = append(locales, Locale{Code: code, Name: c.Name})
+	}
+	sort.Slice(locales, func(i, j int) bool {
+		if (locales[i].Code == DefaultLocale) != (locales[j].Code == DefaultLocale) {
+			return locales[i].Code == DefaultLocale
+		}
+		return locales[i].Code < locales[j].Code
+	})
+	return locales
+}
+
+// LocaleName returns a locale's name in its own language, or the code of a locale
+// the site isn't translated to
+func LocaleName(code string) string {
+	if c, ok := catalogs[code]; ok {
+		return c.Name
+	}
+	return code
+}
+
+// Match returns the supported locale for a language tag such as "es-MX", and
+// whether there is one
+func Match(tag string) (string, bool) {
+	language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	language, _, _ = strings.Cut(language, "_")
+	if _, ok := catalogs[language]; ok {
+		return language, true
+	}
+	return "", false
+}
+
+// Negotiate returns the supported locale an Accept-Language header rates highest,
+// or the default locale
+func Negotiate(acceptLanguage string) string {
+	best, bestQuality := DefaultLocale, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			quality = parsed
+		}
+
+		if locale, ok := Match(tag); ok && quality > bestQuality {
+			best, bestQuality = locale, quality
+		}
+	}
+	return best
+}
+
+// T translates a message, formatting it with the arguments
+func T(locale, key string, args ...any) string {
+	return format(lookup(locale, key)["other"], args)
+}
+
+// N translates a message that depends on a count, picking the plural form for the
+// count. The count is the first argument of the format, before any others.
+func N(locale, key string, count int, args ...any) string {
+	forms := lookup(locale, key)
+	form, ok := forms[pluralCategory(count)]
+	if !ok {
+		form = forms["other"]
+	}
+	return format(form, append([]any{count}, args...))
+}
+
+// Has reports whether the locale's catalog or the default one has a message
+func Has(locale, key string) bool {
+	_, inLocale := catalogs[locale].Messages[key]
+	_, inDefault := catalogs[DefaultLocale].Messages[key]
+	return inLocale || inDefault
+}
+
+// lookup finds a message in the locale's catalog, then the default one. Unknown
+// keys come back as themselves, so a missing translation shows rather than breaks.
+func lookup(locale, key string) message {
+	if m, ok := catalogs[locale].Messages[key]; ok {
+		return m
+	}
+	if m, ok := catalogs[DefaultLocale].Messages[key]; ok {
+		return m
+	}
+	return message{"other": key}
+}
+
+// format applies the arguments to a message, leaving messages without any alone so
+// a literal % doesn't need escaping
+func format(text string, args []any) string {
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// pluralCategory returns the CLDR plural category of a count. English and Spanish
+// share the rule telling "one" from "other"; a locale with other categories needs
+// its own rule picked here.
+func pluralCategory(count int) string {
+	if count == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+// Date styles for FormatDate
+const (
+	// StyleDate is a full date, like "January 2, 2006"
+	StyleDate = "date"
+	// StyleShortDate is a date with a short month, like "Jan 02, 2006"
+	StyleShortDate = "short_date"
+	// StyleDateTime is a short date with the time, like "Jan 02, 2006 15:04"
+	StyleDateTime = "date_time"
+)
+
+// FormatDate formats a time in the locale's style
+func FormatDate(locale string, t time.Time, style string) string {
+	c, ok := catalogs[locale]
+	if !ok {
+		c = catalogs[DefaultLocale]
+	}
+	if len(c.Dates.Months) != 12 {
+		// Catalogs aren't loaded
+		return t.Format("January 2, 2006")
+	}
+
+	layout := c.Dates.Date
+	switch style {
+	case StyleShortDate:
+		layout = c.Dates.ShortDate
+	case StyleDateTime:
+		layout = c.Dates.DateTime
+	}
+
+	return strings.NewReplacer(
+		"{month}", c.Dates.Months[t.Month()-1],
+		"{mon}", c.Dates.ShortMonths[t.Month()-1],
+		"{day}", strconv.Itoa(t.Day()),
+		"{dd}", fmt.Sprintf("%02d", t.Day()),
+		"{year}", strconv.Itoa(t.Year()),
+		"{hh}", fmt.Sprintf("%02d", t.Hour()),
+		"{mm}", fmt.Sprintf("%02d", t.Minute()),
+	).Replace(layout)
+}
+
+// Funcs returns the template functions, which all take the locale first:
+//
+//	t         {{ t .Lang "posts.empty" }}
+//	tn        {{ tn .Lang "two_factor.codes_left" .RecoveryCodesLeft }}
+//	date      {{ date .Lang .Post.Created }}
+//	shortDate {{ shortDate .Lang .Created }}
+//	dateTime  {{ dateTime .Lang .LastUsedAt }}
+//
+// along with localeName, which names a locale: {{ localeName .Post.Lang }}
+func Funcs() map[string]any {
+	return map[string]any{
+		"t":          T,
+		"tn":         N,
+		"localeName": LocaleName,
+		"date": func(locale string, t time.Time) string {
+			return FormatDate(locale, t, StyleDate)
+		},
+		"shortDate": func(locale string, t time.Time) string {
+			return FormatDate(locale, t, StyleShortDate)
+		},
+		"dateTime": func(locale string, t time.Time) string {
+			return FormatDate(locale, t, StyleDateTime)
+		},
+	}
+}
@@ -0,0 +1,30 @@
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileTransport writes every message to its own .eml file in a directory, for
+// development and tests that want to look at what would have been sent
+type FileTransport struct {
+	Dir string
+}
+
+// Send writes the message to a new file named after the time it was sent
+func (t FileTransport) Send(from, to string, message []byte) error {
+	if err := os.MkdirAll(t.Dir, 0o700); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	// Messages hold single-use links, so keep them private
+	return os.WriteFile(filepath.Join(t.Dir, name), message, 0o600)
+}
@@ -0,0 +1,177 @@
+// Package mail sends plain text emails through a pluggable transport: an SMTP
+// server in production, or files and the log during development and tests.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is an email to send
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Transport delivers encoded messages
+type Transport interface {
+	// Send delivers the message, encoded as RFC 5322 with CRLF line endings,
+	// from the sender to the recipient, both given as bare addresses
+	Send(from, to string, message []byte) error
+}
+
+// Mailer sends messages from one address through a transport
+type Mailer struct {
+	From      string
+	Transport Transport
+}
+
+// defaultMailer is the mailer used by Send, see LoadConfig
+var defaultMailer = &Mailer{From: "blog@localhost", Transport: LogTransport{}}
+
+// LoadConfig sets up the mailer used by Send from the environment:
+//
+//	MAIL_TRANSPORT  smtp, file or log (default)
+//	MAIL_FROM       the sender address
+//	MAIL_DIR        where the file transport writes messages
+//	SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD, SMTP_TLS  see SMTPTransport
+func LoadConfig() error {
+	from := getEnv("MAIL_FROM", "blog@localhost")
+	if _, err := mail.ParseAddress(from); err != nil {
+		return fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
+
+	var transport Transport
+	switch getEnv("MAIL_TRANSPORT", "log") {
+	case "smtp":
+		smtpTransport := &SMTPTransport{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     getEnv("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			TLS:      TLSMode(getEnv("SMTP_TLS", string(TLSStartTLS))),
+		}
+		if smtpTransport.Host == "" {
+			return errors.New("SMTP_HOST is required with MAIL_TRANSPORT=smtp")
+		}
+		if !smtpTransport.TLS.valid() {
+			return fmt.Errorf("invalid SMTP_TLS %q", smtpTransport.TLS)
+		}
+		transport = smtpTransport
+	case "file":
+		transport = FileTransport{Dir: getEnv("MAIL_DIR", "mail")}
+	case "log":
+		transport = LogTransport{}
+	default:
+		return fmt.Errorf("unknown MAIL_TRANSPORT %q", os.Getenv("MAIL_TRANSPORT"))
+	}
+
+	defaultMailer = &Mailer{From: from, Transport: transport}
+	return nil
+}
+
+// Send sends a message with the mailer set up by LoadConfig
+func Send(message Message) error {
+	return defaultMailer.Send(message)
+}
+
+// Send encodes a message and hands it to the transport
+func (m *Mailer) Send(message Message) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	encoded, err := encode(from, to, message)
+	if err != nil {
+		return err
+	}
+	return m.Transport.Send(from.Address, to.Address, encoded)
+}
+
+// encode formats a message as a plain text, quoted-printable email
+func encode(from, to *mail.Address, message Message) ([]byte, error) {
+	// Header values must stay on their line
+	if strings.ContainsAny(message.Subject, "\r\n") {
+		return nil, errors.New("subject contains a line break")
+	}
+
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	// Normalize line endings, then encode the body
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	body = strings.ReplaceAll(body, "\n", "\r\n")
+	writer := quotedprintable.NewWriter(&buf)
+	if _, err := writer.Write([]byte(body)); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// messageID returns a unique Message-ID in the sender's domain
+func messageID(address string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		domain = address[at+1:]
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
+
+// LogTransport writes messages to the log instead of sending them, for development
+type LogTransport struct{}
+
+// Send logs the message with its body decoded, so links can be followed
+func (LogTransport) Send(from, to string, message []byte) error {
+	header, body, _ := bytes.Cut(message, []byte("\r\n\r\n"))
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	if err != nil {
+		decoded = body
+	}
+
+	log.Printf("Mail from %s to %s:\n%s\n\n%s", from, to, header, decoded)
+	return nil
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
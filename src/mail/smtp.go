@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// TLSMode is how an SMTPTransport secures its connection
+type TLSMode string
+
+const (
+	// TLSStartTLS upgrades a plain connection with STARTTLS and refuses servers without it
+	TLSStartTLS TLSMode = "starttls"
+	// TLSImplicit connects over TLS from the start, usually on port 465
+	TLSImplicit TLSMode = "tls"
+	// TLSNone never encrypts, for local SMTP stand-ins only
+	TLSNone TLSMode = "none"
+)
+
+// valid reports whether the mode is one of the known modes
+func (m TLSMode) valid() bool {
+	return m == TLSStartTLS || m == TLSImplicit || m == TLSNone
+}
+
+// smtpTimeout bounds the whole conversation with the SMTP server
+const smtpTimeout = 30 * time.Second
+
+// SMTPTransport sends messages through an SMTP server
+type SMTPTransport struct {
+	Host string
+	Port string
+	// Username and Password authenticate with PLAIN auth when set
+	Username string
+	Password string
+	TLS      TLSMode
+	// TLSConfig overrides the TLS settings, such as trusted roots for a test server
+	TLSConfig *tls.Config
+}
+
+// Send delivers the message to the SMTP server
+func (t *SMTPTransport) Send(from, to string, message []byte) error {
+	addr := net.JoinHostPort(t.Host, t.Port)
+	tlsConfig := t.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: t.Host}
+	}
+
+	// Connect, over TLS right away in implicit mode
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if t.TLS == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	defer client.Close()
+
+	// Upgrade the connection before any credentials are sent
+	if t.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp: server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp: %w", err)
+		}
+	}
+
+	if t.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+
+	return client.Quit()
+}
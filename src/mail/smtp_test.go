@@ -0,0 +1,293 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is a local SMTP stand-in that accepts every message and remembers it
+type smtpServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	// startTLS advertises STARTTLS on plain connections
+	startTLS bool
+
+	mu       sync.Mutex
+	received []receivedMail
+}
+
+// receivedMail is a message the server accepted
+type receivedMail struct {
+	from, to string
+	username string
+	password string
+	secure   bool
+	data     []byte
+}
+
+// newSMTPServer starts a server on a random local port. In implicit mode it speaks
+// TLS from the start, otherwise it offers STARTTLS if startTLS is set.
+func newSMTPServer(t *testing.T, implicit, startTLS bool) (*smtpServer, *tls.Config) {
+	t.Helper()
+	serverTLS, clientTLS := testCertificate(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicit {
+		listener = tls.NewListener(listener, serverTLS)
+	}
+
+	s := &smtpServer{listener: listener, tls: serverTLS, startTLS: startTLS}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, implicit)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s, clientTLS
+}
+
+// transport returns an SMTPTransport pointed at the server
+func (s *smtpServer) transport(mode TLSMode, tlsConfig *tls.Config) *SMTPTransport {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return &SMTPTransport{Host: host, Port: port, Username: "blog", Password: "hunter2", TLS: mode, TLSConfig: tlsConfig}
+}
+
+// serve speaks just enough SMTP for net/smtp to deliver a message
+func (s *smtpServer) serve(conn net.Conn, secure bool) {
+	defer func() { conn.Close() }()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP test")
+
+	var mail receivedMail
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			extensions := []string{"localhost", "8BITMIME"}
+			if s.startTLS && !secure {
+				extensions = append(extensions, "STARTTLS")
+			}
+			if secure {
+				extensions = append(extensions, "AUTH PLAIN")
+			}
+			for i, extension := range extensions {
+				separator := "-"
+				if i == len(extensions)-1 {
+					separator = " "
+				}
+				text.PrintfLine("250%s%s", separator, extension)
+			}
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			secure = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			credentials, err := base64.StdEncoding.DecodeString(initial)
+			parts := strings.Split(string(credentials), "\x00")
+			if mechanism != "PLAIN" || err != nil || len(parts) != 3 {
+				text.PrintfLine("535 Authentication failed")
+				continue
+			}
+			mail.username, mail.password = parts[1], parts[2]
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			mail.from = envelopeAddress(arg)
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			mail.to = envelopeAddress(arg)
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = data
+			mail.secure = secure
+			s.mu.Lock()
+			s.received = append(s.received, mail)
+			s.mu.Unlock()
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// envelopeAddress returns the address of a MAIL FROM or RCPT TO argument, without parameters
+func envelopeAddress(arg string) string {
+	_, address, _ := strings.Cut(arg, "<")
+	address, _, _ = strings.Cut(address, ">")
+	return address
+}
+
+// messages returns the messages received so far
+func (s *smtpServer) messages() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMail{}, s.received...)
+}
+
+// testCertificate creates a self-signed certificate for 127.0.0.1 and returns the
+// server's TLS config and a client config trusting it
+func testCertificate(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	return server, client
+}
+
+// sendTestMessage sends a message with non-ASCII text through the transport
+func sendTestMessage(transport Transport) error {
+	mailer := &Mailer{From: "Blog <blog@example.com>", Transport: transport}
+	return mailer.Send(Message{
+		To:      "ana@example.com",
+		Subject: "Restablecer contraseña",
+		Body:    "Hola,\nfollow this link: https://example.com/password/reset?token=abc\n",
+	})
+}
+
+func TestSMTPTransportStartTLS(t *testing.T) {
+	server, clientTLS := newSMTPServer(t, false, true)
+
+	if err := sendTestMessage(server.transport(TLSStartTLS, clientTLS)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	messages := server.messages()
+	if len(messages) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(messages))
+	}
+	got := messages[0]
+	if !got.secure {
+		t.Fatal("message was sent before STARTTLS")
+	}
+	if got.from != "blog@example.com" || got.to != "ana@example.com" {
+		t.Fatalf("envelope = %s -> %s", got.from, got.to)
+	}
+	if got.username != "blog" || got.password != "hunter2" {
+		t.Fatalf("authenticated as %q/%q", got.username, got.password)
+	}
+
+	// The server reads the message with its line endings turned into \n
+	header, body, _ := bytes.Cut(got.data, []byte("\n\n"))
+	if !bytes.Contains(header, []byte("Subject: =?utf-8?q?Restablecer_contrase=C3=B1a?=")) {
+		t.Fatalf("subject is not encoded:\n%s", header)
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(decoded), "Hola,\nfollow this link: https://example.com/password/reset?token=abc") {
+		t.Fatalf("body = %q", decoded)
+	}
+}
+
+func TestSMTPTransportImplicitTLS(t *testing.T) {
+	server, clientTLS := newSMTPServer(t, true, false)
+
+	if err := sendTestMessage(server.transport(TLSImplicit, clientTLS)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if messages := server.messages(); len(messages) != 1 || !messages[0].secure {
+		t.Fatalf("server received %+v", messages)
+	}
+}
+
+func TestSMTPTransportRefusesServerWithoutStartTLS(t *testing.T) {
+	server, clientTLS := newSMTPServer(t, false, false)
+
+	err := sendTestMessage(server.transport(TLSStartTLS, clientTLS))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("Send() error = %v, want missing STARTTLS", err)
+	}
+	if messages := server.messages(); len(messages) != 0 {
+		t.Fatalf("server received %d messages over plain text", len(messages))
+	}
+}
+
+func TestSMTPTransportVerifiesCertificate(t *testing.T) {
+	server, _ := newSMTPServer(t, false, true)
+
+	// Without the TLSConfig hook, the self-signed certificate isn't trusted
+	if err := sendTestMessage(server.transport(TLSStartTLS, nil)); err == nil {
+		t.Fatal("sent a message to a server with an untrusted certificate")
+	}
+	if messages := server.messages(); len(messages) != 0 {
+		t.Fatalf("server received %d messages", len(messages))
+	}
+}
+
+func TestSMTPTransportWithoutTLS(t *testing.T) {
+	server, _ := newSMTPServer(t, false, false)
+
+	// Plain connections are only for local stand-ins, which need no credentials
+	transport := server.transport(TLSNone, nil)
+	transport.Username = ""
+	if err := sendTestMessage(transport); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if messages := server.messages(); len(messages) != 1 || messages[0].secure {
+		t.Fatalf("server received %+v", messages)
+	}
+}
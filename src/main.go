@@ -1,14 +1,17 @@
 package main
 
 import (
-	"context"
 	"log"
 	"net/http"
 	"os"
 
 	"chewawi_web/src/controllers"
 	"chewawi_web/src/database"
+	"chewawi_web/src/i18n"
+	"chewawi_web/src/mail"
 	"chewawi_web/src/middleware"
+	"chewawi_web/src/models"
+	"chewawi_web/src/views"
 
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
@@ -24,64 +27,166 @@ func main() {
 	database.InitDB()
 	defer database.CloseDB()
 
+	// Run a maintenance command instead of the server if one was given
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := middleware.LoadSigningKeys(); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
+	if err := mail.LoadConfig(); err != nil {
+		log.Fatalf("Failed to set up mail: %v", err)
+	}
+
+	if err := i18n.Load(); err != nil {
+		log.Fatalf("Failed to load translations: %v", err)
+	}
+
+	if err := views.Load(); err != nil {
+		log.Fatalf("Failed to load templates: %v", err)
+	}
+
+	if err := middleware.BootstrapAdminPassword(); err != nil {
+		log.Fatalf("Failed to set admin password: %v", err)
+	}
+
 	r := chi.NewRouter()
 
+	r.Use(chimiddleware.RequestID)
 	r.Use(chimiddleware.Logger)
 	r.Use(chimiddleware.Recoverer)
-	r.Use(addUserToContext)
+	r.Use(middleware.LocaleMiddleware)
+	r.Use(middleware.CSRFMiddleware(controllers.ForbiddenHandler))
 
+	// Static files are served without looking up the session
 	fileServer := http.FileServer(http.Dir("static/"))
 	r.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	// Pages and the API resolve the signed-in user from the session cookies
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.SessionMiddleware)
+		routes(r)
+	})
+
+	// Start server
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8081"
+	}
+
+	log.Printf("Server starting on port %s...\n", port)
+	log.Fatal(http.ListenAndServe(":"+port, r))
+}
+
+// routes registers the page and API routes
+func routes(r chi.Router) {
+	r.NotFound(controllers.NotFoundHandler)
+
 	// Public routes
 	r.Get("/", controllers.HomeHandler)
 	r.Get("/posts", controllers.ListPostsHandler)
+	r.Get("/posts.json", controllers.ListPostsHandler)
 	r.Get("/posts/{slug}", controllers.ViewPostHandler)
+	r.Get("/authors/{username}", controllers.AuthorHandler)
+	r.Get("/language/{locale}", controllers.SwitchLocaleHandler)
 
 	// Authentication routes
 	r.Get("/login", controllers.LoginHandler)
 	r.Post("/login", controllers.LoginSubmitHandler)
+	r.Get("/login/2fa", controllers.LoginTwoFactorHandler)
+	r.Post("/login/2fa", controllers.LoginTwoFactorSubmitHandler)
+	r.Post("/login/passkey/begin", controllers.BeginPasskeyLoginHandler)
+	r.Post("/login/passkey/finish", controllers.FinishPasskeyLoginHandler)
+	r.Get("/login/oidc", controllers.OIDCLoginHandler)
+	r.Get("/login/oidc/callback", controllers.OIDCCallbackHandler)
 	r.Post("/logout", controllers.LogoutHandler)
 
+	// Password reset and invitation routes, reached from links in emails
+	r.Get("/password/forgot", controllers.ForgotPasswordHandler)
+	r.Post("/password/forgot", controllers.ForgotPasswordSubmitHandler)
+	r.Get("/password/reset", controllers.ResetPasswordHandler)
+	r.Post("/password/reset", controllers.ResetPasswordSubmitHandler)
+	r.Get("/invite", controllers.AcceptInviteHandler)
+	r.Post("/invite", controllers.AcceptInviteSubmitHandler)
+
 	// Admin routes (protected)
 	r.Route("/owner", func(r chi.Router) {
 		// Use auth middleware for all /owner routes
 		r.Use(middleware.AuthMiddleware)
 
-		r.Get("/", controllers.DashboardHandler)
-		r.Get("/new", controllers.NewPostHandler)
-		r.Post("/new", controllers.CreatePostHandler)
-		r.Get("/edit/{slug}", controllers.EditPostHandler)
-		r.Post("/edit/{slug}", controllers.UpdatePostHandler)
-		r.Post("/delete/{slug}", controllers.DeletePostHandler)
+		// Two-factor enrollment stays reachable for admins who still have to enroll
+		r.Get("/2fa", controllers.TwoFactorHandler)
+		r.Post("/2fa/setup", controllers.SetupTwoFactorHandler)
+		r.Post("/2fa/enable", controllers.EnableTwoFactorHandler)
+		r.Post("/2fa/disable", controllers.DisableTwoFactorHandler)
+
+		r.Group(func(r chi.Router) {
+			r.Use(controllers.RequireTwoFactorEnrollment)
+
+			r.With(controllers.RequirePermission(models.PermViewDashboard)).Get("/", controllers.DashboardHandler)
+			r.Get("/profile", controllers.EditProfileHandler)
+			r.Post("/profile", controllers.UpdateProfileHandler)
+
+			// Passkey routes
+			r.Get("/passkeys", controllers.PasskeysHandler)
+			r.Post("/passkeys/register/begin", controllers.BeginPasskeyRegistrationHandler)
+			r.Post("/passkeys/register/finish", controllers.FinishPasskeyRegistrationHandler)
+			r.Post("/passkeys/{id}/delete", controllers.DeletePasskeyHandler)
+
+			// Session routes
+			r.Get("/sessions", controllers.SessionsHandler)
+			r.Post("/sessions/revoke-others", controllers.RevokeOtherSessionsHandler)
+			r.Post("/sessions/{id}/revoke", controllers.RevokeSessionHandler)
+
+			// API token routes
+			r.Get("/tokens", controllers.APITokensHandler)
+			r.Post("/tokens", controllers.CreateAPITokenHandler)
+			r.Post("/tokens/{id}/delete", controllers.DeleteAPITokenHandler)
+
+			// Post routes; handlers also check the user may touch the specific post
+			r.Group(func(r chi.Router) {
+				r.Use(controllers.RequirePermission(models.PermWritePosts))
+
+				r.Get("/new", controllers.NewPostHandler)
+				r.Post("/new", controllers.CreatePostHandler)
+				r.Get("/edit/{slug}", controllers.EditPostHandler)
+				r.Post("/edit/{slug}", controllers.UpdatePostHandler)
+				r.Post("/delete/{slug}", controllers.DeletePostHandler)
+			})
+
+			// User management routes
+			r.Group(func(r chi.Router) {
+				r.Use(controllers.RequirePermission(models.PermManageUsers))
+
+				r.Get("/users", controllers.ListUsersHandler)
+				r.Post("/users", controllers.CreateUserHandler)
+				r.Post("/users/{username}/role", controllers.UpdateUserRoleHandler)
+				r.Get("/login-attempts", controllers.LoginAttemptsHandler)
+			})
+
+			// Settings routes
+			r.Group(func(r chi.Router) {
+				r.Use(controllers.RequirePermission(models.PermManageSettings))
+
+				r.Get("/settings", controllers.SettingsHandler)
+				r.Post("/settings", controllers.UpdateSettingsHandler)
+			})
+		})
 	})
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
-	}
-
-	log.Printf("Server starting on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
-}
-
-// addUserToContext middleware adds the username to the request context if the user is authenticated
-func addUserToContext(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("token")
-		if err == nil {
-
-			claims := &middleware.Claims{}
-			token, err := middleware.ParseToken(cookie.Value, claims)
-
-			if err == nil && token.Valid {
-				ctx := context.WithValue(r.Context(), "username", claims.Username)
-				next.ServeHTTP(w, r.WithContext(ctx))
-				return
-			}
-		}
+	// Machine API, authenticated with personal access tokens
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(middleware.APITokenMiddleware)
 
-		next.ServeHTTP(w, r)
+		r.With(controllers.RequireScope(models.ScopePostsRead)).Get("/posts", controllers.APIListPostsHandler)
+		r.With(controllers.RequireScope(models.ScopePostsRead)).Get("/posts/{slug}", controllers.APIGetPostHandler)
+		r.With(controllers.RequireScope(models.ScopePostsWrite)).Post("/posts", controllers.APICreatePostHandler)
+		r.With(controllers.RequireScope(models.ScopePostsWrite)).Put("/posts/{slug}", controllers.APIUpdatePostHandler)
+		r.With(controllers.RequireScope(models.ScopePostsDelete)).Delete("/posts/{slug}", controllers.APIDeletePostHandler)
 	})
 }
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"chewawi_web/src/models"
+)
+
+// apiTokenPrefix starts every personal access token, so leaked ones are easy to spot
+const apiTokenPrefix = "cw_"
+
+// GenerateAPIToken returns a new personal access token, the prefix to show for it
+// and the hash to store
+func GenerateAPIToken() (string, string, string) {
+	token := apiTokenPrefix + randomToken()
+	return token, token[:len(apiTokenPrefix)+8], HashAPIToken(token)
+}
+
+// HashAPIToken hashes a personal access token for storage and lookup
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APITokenMiddleware authenticates machine API requests with a personal access token
+// sent as "Authorization: Bearer <token>", and answers anything else with a 401
+func APITokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Get the token from the Authorization header
+		value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(value, apiTokenPrefix) {
+			writeUnauthorized(w, "missing bearer token")
+			return
+		}
+
+		// Look it up along with its user
+		token, err := models.GetActiveAPIToken(HashAPIToken(value))
+		if err != nil {
+			writeUnauthorized(w, "invalid or expired token")
+			return
+		}
+
+		user, err := models.GetUserByID(token.UserID)
+		if err != nil {
+			writeUnauthorized(w, "invalid or expired token")
+			return
+		}
+
+		if err := models.TouchAPIToken(token.ID, ClientIP(r)); err != nil {
+			log.Printf("Error updating API token: %v", err)
+		}
+
+		// Token is valid, proceed with the request as the token's user
+		next.ServeHTTP(w, withCurrentUser(r, CurrentUser{User: user, APIToken: &token}))
+	})
+}
+
+// writeUnauthorized answers an API request with a 401 JSON error
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
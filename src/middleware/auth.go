@@ -1,40 +1,72 @@
 package middleware
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte(getEnv("JWT_SECRET", "your-secret-key"))
 var adminUser = getEnv("ADMIN_USER", "admin")
-var adminPassword = getEnv("ADMIN_PASSWORD", "password")
+
+// contextKey is the type of the request context keys set by this package
+type contextKey string
+
+// pendingTwoFactorPurpose marks tokens issued after the password step of a two-factor login
+const pendingTwoFactorPurpose = "2fa"
+
+// pendingTwoFactorLifetime is how long a user has to enter their second factor
+const pendingTwoFactorLifetime = 5 * time.Minute
 
 // Claims represents the JWT claims
 type Claims struct {
 	Username string `json:"username"`
+	// Purpose is empty for session tokens and set for short-lived tokens of a login step
+	Purpose string `json:"purpose,omitempty"`
+	// Remember and Next carry the login page's choices through the login steps
+	Remember bool   `json:"remember,omitempty"`
+	Next     string `json:"next,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// Authenticate checks if the provided username and password are valid
-func Authenticate(username, password string) bool {
-	return username == adminUser && password == adminPassword
+// GenerateToken generates a short-lived JWT access token for the authenticated user's session
+func GenerateToken(username, sessionID string) (string, error) {
+	expirationTime := time.Now().Add(accessTokenLifetime)
+	claims := &Claims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+		},
+	}
+
+	return signToken(claims)
 }
 
-// GenerateToken generates a JWT token for the authenticated user
-func GenerateToken(username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GeneratePendingTwoFactorToken generates a short-lived token for a user who
+// passed the password step but still has to enter their second factor
+func GeneratePendingTwoFactorToken(username string, options LoginOptions) (string, error) {
 	claims := &Claims{
 		Username: username,
+		Purpose:  pendingTwoFactorPurpose,
+		Remember: options.Remember,
+		Next:     options.Next,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(pendingTwoFactorLifetime)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	return signToken(claims)
+}
+
+// signToken signs the claims with the active signing key
+func signToken(claims *Claims) (string, error) {
+	token := jwt.NewWithClaims(signingMethod, claims)
+	tokenString, err := signWithActiveKey(token)
 	if err != nil {
 		return "", err
 	}
@@ -42,34 +74,45 @@ func GenerateToken(username string) (string, error) {
 	return tokenString, nil
 }
 
-// ParseToken parses a JWT token and returns the token and claims
+// ParseToken parses a session JWT token and returns the token and claims
 func ParseToken(tokenStr string, claims *Claims) (*jwt.Token, error) {
-	return jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := parseClaims(tokenStr, claims)
+	if err == nil && claims.Purpose != "" {
+		return token, errors.New("not a session token")
+	}
+	return token, err
 }
 
-// AuthMiddleware is a middleware that checks if the user is authenticated
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the JWT token from the cookie
-		cookie, err := r.Cookie("token")
-		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
-		}
+// ParsePendingTwoFactorToken parses a token issued by GeneratePendingTwoFactorToken
+// and returns the username it was issued for and the choices of the login page
+func ParsePendingTwoFactorToken(tokenStr string) (string, LoginOptions, error) {
+	claims := &Claims{}
+	token, err := parseClaims(tokenStr, claims)
+	if err != nil || !token.Valid {
+		return "", LoginOptions{}, errors.New("invalid two-factor token")
+	}
+	if claims.Purpose != pendingTwoFactorPurpose {
+		return "", LoginOptions{}, errors.New("not a two-factor token")
+	}
+	return claims.Username, LoginOptions{Remember: claims.Remember, Next: claims.Next}, nil
+}
 
-		// Parse the JWT token
-		tokenStr := cookie.Value
-		claims := &Claims{}
-		token, err := ParseToken(tokenStr, claims)
+// parseClaims parses and verifies a JWT token signed with one of the signing keys
+func parseClaims(tokenStr string, claims *Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenStr, claims, verificationKey, jwt.WithValidMethods([]string{signingMethod.Alg()}))
+}
 
-		if err != nil || !token.Valid {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+// AuthMiddleware is a middleware that checks if the user is authenticated.
+// It relies on SessionMiddleware having resolved the session.
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := SessionFromContext(r.Context()); !ok {
+			// Come back to the requested page after signing in
+			http.Redirect(w, r, LoginURL(r), http.StatusSeeOther)
 			return
 		}
 
-		// Token is valid, proceed with the request
+		// Session is valid, proceed with the request
 		next.ServeHTTP(w, r)
 	})
 }
@@ -82,3 +125,23 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvInt gets an integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnv(key, strconv.Itoa(defaultValue)))
+	if err != nil {
+		log.Printf("Warning: invalid %s, using %d", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration gets a duration environment variable such as "15m" or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, defaultValue.String()))
+	if err != nil {
+		log.Printf("Warning: invalid %s, using %s", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckCSRFOrigin(t *testing.T) {
+	const token = "0123456789012345678901234567890123456789012"
+
+	tests := []struct {
+		name    string
+		siteURL string
+		host    string
+		origin  string
+		referer string
+		wantErr bool
+	}{
+		{name: "same host", host: "example.com", origin: "https://example.com"},
+		{name: "other origin", host: "example.com", origin: "https://evil.example", wantErr: true},
+		{name: "other referer", host: "example.com", referer: "https://evil.example/page", wantErr: true},
+		{name: "no origin or referer", host: "example.com"},
+		{name: "proxy rewrites host", siteURL: "https://blog.example.com/", host: "127.0.0.1:8081", origin: "https://blog.example.com"},
+		{name: "proxy with referer", siteURL: "https://blog.example.com", host: "app:8081", referer: "https://blog.example.com/owner"},
+		{name: "site URL beats host", siteURL: "https://blog.example.com", host: "evil.example", origin: "https://evil.example", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SITE_URL", tt.siteURL)
+
+			r := httptest.NewRequest(http.MethodPost, "/owner/posts", strings.NewReader(""))
+			r.Host = tt.host
+			r.Header.Set(CSRFHeader, token)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+
+			err := checkCSRF(r, token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkCSRF() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"chewawi_web/src/models"
+)
+
+// currentUserContextKey is the context key of the request's CurrentUser
+const currentUserContextKey contextKey = "current-user"
+
+// CurrentUser is who made a request: the signed-in user along with the browser
+// session or API token they authenticated with
+type CurrentUser struct {
+	models.User
+	// Session is set for requests made from a signed-in browser
+	Session *models.Session
+	// APIToken is set for requests made with a personal access token
+	APIToken *models.APIToken
+}
+
+// Has reports whether the user's role grants the named permission, for templates
+func (c CurrentUser) Has(permission string) bool {
+	return c.Can(models.Permission(permission))
+}
+
+// SessionMiddleware resolves the signed-in user of every request from the session
+// cookies, renewing the access token when needed. Anonymous requests go through
+// unchanged; AuthMiddleware turns them away where a user is required.
+func SessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, session, err := SessionFromRequest(w, r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, withCurrentUser(r, CurrentUser{User: user, Session: &session}))
+	})
+}
+
+// withCurrentUser returns the request with the user stored in its context
+func withCurrentUser(r *http.Request, current CurrentUser) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), currentUserContextKey, current))
+}
+
+// CurrentUserFromContext returns the user who made the request, if anyone is signed in
+func CurrentUserFromContext(ctx context.Context) (CurrentUser, bool) {
+	current, ok := ctx.Value(currentUserContextKey).(CurrentUser)
+	return current, ok
+}
+
+// UserFromContext returns the signed-in user of the request
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	current, ok := CurrentUserFromContext(ctx)
+	return current.User, ok
+}
+
+// SessionFromContext returns the browser session of the request
+func SessionFromContext(ctx context.Context) (models.Session, bool) {
+	current, ok := CurrentUserFromContext(ctx)
+	if !ok || current.Session == nil {
+		return models.Session{}, false
+	}
+	return *current.Session, true
+}
+
+// APITokenFromContext returns the API token the request was made with
+func APITokenFromContext(ctx context.Context) (models.APIToken, bool) {
+	current, ok := CurrentUserFromContext(ctx)
+	if !ok || current.APIToken == nil {
+		return models.APIToken{}, false
+	}
+	return *current.APIToken, true
+}
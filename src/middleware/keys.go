@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultJWTSecrets are the well-known secrets that must never sign tokens in production
+var defaultJWTSecrets = []string{"", "your-secret-key", "your-secret-key-change-this-in-production"}
+
+// envKeyID is the key ID of the key read from JWT_SECRET when there is no keyset file
+const envKeyID = "env"
+
+// minSecretLength is the shortest secret accepted in a keyset file, in bytes
+const minSecretLength = 32
+
+// signingKey is an HMAC key tokens are signed or verified with
+type signingKey struct {
+	ID     string
+	Secret []byte
+	// Expires is when the key stops being accepted, zero if it doesn't expire
+	Expires time.Time
+}
+
+// expired reports whether tokens signed with the key are no longer accepted
+func (k signingKey) expired() bool {
+	return !k.Expires.IsZero() && time.Now().After(k.Expires)
+}
+
+// keysetFile is the format of the JWT_KEYSET_FILE file
+type keysetFile struct {
+	// Active is the ID of the key new tokens are signed with
+	Active string `json:"active"`
+	Keys   []struct {
+		ID string `json:"kid"`
+		// Secret is the base64 encoded key
+		Secret  string    `json:"secret"`
+		Expires time.Time `json:"expires"`
+	} `json:"keys"`
+}
+
+// activeKey signs new tokens
+var activeKey signingKey
+
+// verificationKeys are the keys tokens are accepted from, by key ID
+var verificationKeys = map[string]signingKey{}
+
+// LoadSigningKeys loads the JWT signing keys, from the keyset file in JWT_KEYSET_FILE
+// if set and from JWT_SECRET otherwise. In production (APP_ENV=production) it refuses
+// to use one of the default secrets.
+func LoadSigningKeys() error {
+	production := os.Getenv("APP_ENV") == "production"
+
+	if path := os.Getenv("JWT_KEYSET_FILE"); path != "" {
+		return loadKeyset(path)
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	for _, defaultSecret := range defaultJWTSecrets {
+		if secret != defaultSecret {
+			continue
+		}
+		if production {
+			return errors.New("JWT_SECRET is not set or uses the default value; refusing to start in production")
+		}
+		log.Println("Warning: using the default JWT secret, anyone can forge sessions; set JWT_SECRET or JWT_KEYSET_FILE")
+		if secret == "" {
+			secret = "your-secret-key"
+		}
+		break
+	}
+
+	activeKey = signingKey{ID: envKeyID, Secret: []byte(secret)}
+	verificationKeys = map[string]signingKey{envKeyID: activeKey}
+	return nil
+}
+
+// loadKeyset loads the signing keys from a keyset file, skipping the expired ones
+func loadKeyset(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading JWT keyset: %w", err)
+	}
+
+	var keyset keysetFile
+	if err := json.Unmarshal(content, &keyset); err != nil {
+		return fmt.Errorf("parsing JWT keyset: %w", err)
+	}
+
+	keys := map[string]signingKey{}
+	for _, entry := range keyset.Keys {
+		secret, err := base64.StdEncoding.DecodeString(entry.Secret)
+		if err != nil {
+			return fmt.Errorf("JWT key %q: secret is not valid base64", entry.ID)
+		}
+		if entry.ID == "" || len(secret) < minSecretLength {
+			return fmt.Errorf("JWT key %q: needs a kid and a secret of at least %d bytes", entry.ID, minSecretLength)
+		}
+		if _, ok := keys[entry.ID]; ok {
+			return fmt.Errorf("JWT key %q is listed twice", entry.ID)
+		}
+
+		key := signingKey{ID: entry.ID, Secret: secret, Expires: entry.Expires}
+		if key.expired() {
+			continue
+		}
+		keys[key.ID] = key
+	}
+
+	active, ok := keys[keyset.Active]
+	if !ok {
+		return fmt.Errorf("JWT keyset: active key %q is missing or expired", keyset.Active)
+	}
+
+	activeKey = active
+	verificationKeys = keys
+	log.Printf("Loaded %d JWT signing keys, signing with %q", len(keys), active.ID)
+	return nil
+}
+
+// signingMethod is the algorithm tokens are signed with
+var signingMethod = jwt.SigningMethodHS256
+
+// signWithActiveKey signs a token with the active key, naming it in the kid header
+func signWithActiveKey(token *jwt.Token) (string, error) {
+	if activeKey.Secret == nil {
+		return "", errors.New("signing keys are not loaded")
+	}
+
+	token.Header["kid"] = activeKey.ID
+	return token.SignedString(activeKey.Secret)
+}
+
+// verificationKey finds the key a token was signed with from its kid header
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	// Tokens issued before key IDs were introduced were signed with JWT_SECRET
+	if kid == "" {
+		kid = envKeyID
+	}
+
+	key, ok := verificationKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if key.expired() {
+		return nil, fmt.Errorf("signing key %q has expired", kid)
+	}
+
+	return key.Secret, nil
+}
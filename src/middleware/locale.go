@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"chewawi_web/src/i18n"
+)
+
+// localeContextKey is the context key of the request's locale
+const localeContextKey contextKey = "locale"
+
+// localeCookie remembers the locale a visitor picked
+const localeCookie = "lang"
+
+// localeCookieLifetime is how long a picked locale is remembered
+const localeCookieLifetime = 365 * 24 * time.Hour
+
+// LocaleMiddleware picks the locale of every request, from the first of:
+//
+//   - a locale prefix on the path, like /es/posts, which is stripped before routing
+//     and remembered in the cookie
+//   - the cookie set when the visitor picked a locale
+//   - the Accept-Language header
+func LocaleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if locale, rest, ok := splitLocalePrefix(r.URL.Path); ok {
+			if cookie, err := r.Cookie(localeCookie); err != nil || cookie.Value != locale {
+				SetLocaleCookie(w, locale)
+			}
+
+			// Route the request as if it had no prefix
+			r = withLocale(r, locale)
+			unprefixed := *r.URL
+			unprefixed.Path = rest
+			unprefixed.RawPath = ""
+			r.URL = &unprefixed
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cookie, err := r.Cookie(localeCookie); err == nil {
+			if locale, ok := i18n.Match(cookie.Value); ok {
+				AddVary(w.Header(), "Cookie")
+				next.ServeHTTP(w, withLocale(r, locale))
+				return
+			}
+		}
+
+		AddVary(w.Header(), "Accept-Language", "Cookie")
+		next.ServeHTTP(w, withLocale(r, i18n.Negotiate(r.Header.Get("Accept-Language"))))
+	})
+}
+
+// splitLocalePrefix splits a path like /es/posts into the locale and the rest of the
+// path, reporting whether it starts with a supported locale
+func splitLocalePrefix(path string) (string, string, bool) {
+	segment, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if len(segment) != 2 {
+		return "", "", false
+	}
+
+	locale, ok := i18n.Match(segment)
+	if !ok || locale != segment {
+		return "", "", false
+	}
+	return locale, "/" + rest, true
+}
+
+// SetLocaleCookie remembers the locale a visitor picked
+func SetLocaleCookie(w http.ResponseWriter, locale string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     localeCookie,
+		Value:    locale,
+		Expires:  time.Now().Add(localeCookieLifetime),
+		HttpOnly: true,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// withLocale returns the request with the locale in its context
+func withLocale(r *http.Request, locale string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), localeContextKey, locale))
+}
+
+// LocaleFromContext returns the locale picked for the request, or the default locale
+func LocaleFromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeContextKey).(string); ok {
+		return locale
+	}
+	return i18n.DefaultLocale
+}
+
+// AddVary adds request headers to the Vary header of a response, skipping any
+// already listed
+func AddVary(header http.Header, fields ...string) {
+	listed := make(map[string]bool)
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			listed[http.CanonicalHeaderKey(strings.TrimSpace(field))] = true
+		}
+	}
+
+	for _, field := range fields {
+		if !listed[http.CanonicalHeaderKey(field)] {
+			header.Add("Vary", field)
+			listed[http.CanonicalHeaderKey(field)] = true
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"chewawi_web/src/models"
+	"chewawi_web/src/oidc"
+)
+
+// oidcLoginLifetime is how long a user has to come back from the identity provider
+const oidcLoginLifetime = 10 * time.Minute
+
+// oidcProvider is the discovered identity provider, set up on first use
+var oidcProvider = struct {
+	sync.Mutex
+	provider *oidc.Provider
+}{}
+
+// oidcLogins holds the logins waiting for the identity provider, keyed by state.
+// Each can only be finished once.
+var oidcLogins = struct {
+	sync.Mutex
+	pending map[string]oidcLogin
+}{pending: make(map[string]oidcLogin)}
+
+// oidcLogin is a login waiting for the identity provider
+type oidcLogin struct {
+	nonce        string
+	codeVerifier string
+	options      LoginOptions
+	expires      time.Time
+}
+
+// OIDCEnabled reports whether single sign-on with an identity provider is configured
+func OIDCEnabled() bool {
+	return os.Getenv("OIDC_ISSUER") != ""
+}
+
+// OIDCProviderName returns the name of the identity provider shown on the login page
+func OIDCProviderName() string {
+	if !OIDCEnabled() {
+		return ""
+	}
+	return getEnv("OIDC_PROVIDER_NAME", "single sign-on")
+}
+
+// BeginOIDCLogin starts a login with the identity provider and returns the URL to
+// send the browser to, and the state to remember in a cookie until it comes back
+func BeginOIDCLogin(ctx context.Context, options LoginOptions) (string, string, error) {
+	provider, err := getOIDCProvider(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := oidc.RandomString()
+	login := oidcLogin{
+		nonce:        oidc.RandomString(),
+		codeVerifier: oidc.RandomString(),
+		options:      options,
+		expires:      time.Now().Add(oidcLoginLifetime),
+	}
+
+	oidcLogins.Lock()
+	defer oidcLogins.Unlock()
+
+	// Drop the logins that were abandoned
+	for id, pending := range oidcLogins.pending {
+		if time.Now().After(pending.expires) {
+			delete(oidcLogins.pending, id)
+		}
+	}
+	oidcLogins.pending[state] = login
+
+	return provider.AuthCodeURL(state, login.nonce, login.codeVerifier), state, nil
+}
+
+// FinishOIDCLogin redeems the code the identity provider sent back for the login
+// with the state, and returns the local user of the identity and the choices
+// of the login page
+func FinishOIDCLogin(ctx context.Context, state, code string) (models.User, LoginOptions, error) {
+	oidcLogins.Lock()
+	login, ok := oidcLogins.pending[state]
+	delete(oidcLogins.pending, state)
+	oidcLogins.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return models.User{}, LoginOptions{}, errors.New("unknown or expired login")
+	}
+
+	// From here on the options are returned with errors too, so the login page can keep them
+	provider, err := getOIDCProvider(ctx)
+	if err != nil {
+		return models.User{}, login.options, err
+	}
+
+	claims, err := provider.Exchange(ctx, code, login.codeVerifier, login.nonce)
+	if err != nil {
+		return models.User{}, login.options, err
+	}
+
+	user, err := oidcUser(claims)
+	if err != nil {
+		return models.User{}, login.options, err
+	}
+	return user, login.options, nil
+}
+
+// oidcUser finds or creates the local user of an identity and syncs their role.
+//
+// Users are matched by the identity's subject. The first time, a new user is created
+// under the username claim; an existing user with that name is only linked when
+// OIDC_LINK_EXISTING_USERS is true.
+//
+// With OIDC_ROLE_MAPPING set, the role follows the provider's groups on every login.
+// Identities without a mapped group get OIDC_DEFAULT_ROLE, or are refused if it's empty.
+func oidcUser(claims oidc.Claims) (models.User, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+
+	role, mapped := oidcRole(claims)
+	if role == "" {
+		return models.User{}, fmt.Errorf("identity %s is not in any group allowed to sign in", claims.Subject)
+	}
+
+	// Returning users
+	user, err := models.GetUserByOIDCSubject(issuer, claims.Subject)
+	if err == nil {
+		if mapped && user.Role != role {
+			return models.UpdateUserRole(user.ID, role)
+		}
+		return user, nil
+	}
+
+	// First sign-in of the identity
+	username := claims.String(getEnv("OIDC_USERNAME_CLAIM", "preferred_username"))
+	if username == "" {
+		return models.User{}, errors.New("identity has no username claim")
+	}
+
+	user, err = models.GetUserByUsername(username)
+	if err == nil {
+		if os.Getenv("OIDC_LINK_EXISTING_USERS") != "true" {
+			return models.User{}, fmt.Errorf("user %s already exists and OIDC_LINK_EXISTING_USERS is off", username)
+		}
+	} else {
+		user, err = models.CreateUser(username, role)
+		if err != nil {
+			return models.User{}, fmt.Errorf("creating user %s: %w", username, err)
+		}
+		if claims.Name != "" {
+			user, err = models.UpdateUserProfile(user.ID, claims.Name, user.Bio, user.AvatarURL)
+			if err != nil {
+				return models.User{}, err
+			}
+		}
+		log.Printf("Created user %s for identity %s", username, claims.Subject)
+	}
+
+	if err := models.LinkOIDCSubject(user.ID, issuer, claims.Subject); err != nil {
+		return models.User{}, err
+	}
+
+	if mapped && user.Role != role {
+		return models.UpdateUserRole(user.ID, role)
+	}
+	return user, nil
+}
+
+// oidcRole returns the most privileged role the identity's groups map to, and
+// whether roles are mapped at all. Unmapped identities get the default role.
+func oidcRole(claims oidc.Claims) (models.Role, bool) {
+	mapping := parseRoleMapping(os.Getenv("OIDC_ROLE_MAPPING"))
+	role := models.Role(os.Getenv("OIDC_DEFAULT_ROLE"))
+	if !role.Valid() {
+		role = ""
+	}
+
+	for _, group := range claims.Strings(getEnv("OIDC_GROUPS_CLAIM", "groups")) {
+		mappedRole, ok := mapping[group]
+		if ok && (role == "" || slices.Index(models.Roles, mappedRole) < slices.Index(models.Roles, role)) {
+			role = mappedRole
+		}
+	}
+
+	return role, len(mapping) > 0
+}
+
+// parseRoleMapping parses a comma separated list of group=role pairs
+func parseRoleMapping(value string) map[string]models.Role {
+	mapping := make(map[string]models.Role)
+	for _, entry := range strings.Split(value, ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		if !models.Role(role).Valid() {
+			log.Printf("Warning: ignoring OIDC role mapping %q, unknown role", entry)
+			continue
+		}
+		mapping[group] = models.Role(role)
+	}
+	return mapping
+}
+
+// getOIDCProvider discovers the identity provider the first time it's needed
+func getOIDCProvider(ctx context.Context) (*oidc.Provider, error) {
+	if !OIDCEnabled() {
+		return nil, errors.New("single sign-on is not configured")
+	}
+
+	oidcProvider.Lock()
+	defer oidcProvider.Unlock()
+
+	if oidcProvider.provider != nil {
+		return oidcProvider.provider, nil
+	}
+
+	scopes := strings.Fields(getEnv("OIDC_SCOPES", "profile email"))
+	provider, err := oidc.NewProvider(ctx, oidc.Config{
+		IssuerURL:    os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       scopes,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	oidcProvider.provider = provider
+	return provider, nil
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"testing"
+
+	"chewawi_web/src/models"
+	"chewawi_web/src/oidc"
+)
+
+func TestOIDCRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		mapping     string
+		defaultRole string
+		groupsClaim string
+		claims      map[string]any
+		wantRole    models.Role
+		wantMapped  bool
+	}{
+		{
+			name:        "no mapping uses the default role",
+			defaultRole: "author",
+			claims:      map[string]any{"groups": []any{"admins"}},
+			wantRole:    models.RoleAuthor,
+		},
+		{
+			name:     "no mapping and no default refuses",
+			claims:   map[string]any{"groups": []any{"admins"}},
+			wantRole: "",
+		},
+		{
+			name:       "mapped group",
+			mapping:    "writers=author, admins=admin",
+			claims:     map[string]any{"groups": []any{"writers"}},
+			wantRole:   models.RoleAuthor,
+			wantMapped: true,
+		},
+		{
+			name:       "most privileged group wins",
+			mapping:    "writers=author,editors=editor,admins=admin",
+			claims:     map[string]any{"groups": []any{"writers", "admins", "editors"}},
+			wantRole:   models.RoleAdmin,
+			wantMapped: true,
+		},
+		{
+			name:        "mapped group beats a less privileged default",
+			mapping:     "editors=editor",
+			defaultRole: "viewer",
+			claims:      map[string]any{"groups": []any{"editors"}},
+			wantRole:    models.RoleEditor,
+			wantMapped:  true,
+		},
+		{
+			name:        "unmapped groups get the default",
+			mapping:     "admins=admin",
+			defaultRole: "viewer",
+			claims:      map[string]any{"groups": []any{"staff"}},
+			wantRole:    models.RoleViewer,
+			wantMapped:  true,
+		},
+		{
+			name:       "unmapped groups without a default are refused",
+			mapping:    "admins=admin",
+			claims:     map[string]any{"groups": []any{"staff"}},
+			wantRole:   "",
+			wantMapped: true,
+		},
+		{
+			name:        "invalid default is ignored",
+			mapping:     "admins=admin",
+			defaultRole: "superuser",
+			claims:      map[string]any{},
+			wantRole:    "",
+			wantMapped:  true,
+		},
+		{
+			name:       "unknown roles in the mapping are ignored",
+			mapping:    "admins=root,writers=author",
+			claims:     map[string]any{"groups": []any{"admins", "writers"}},
+			wantRole:   models.RoleAuthor,
+			wantMapped: true,
+		},
+		{
+			name:        "single group as a string in a custom claim",
+			mapping:     "editors=editor",
+			groupsClaim: "roles",
+			claims:      map[string]any{"roles": "editors", "groups": []any{"admins"}},
+			wantRole:    models.RoleEditor,
+			wantMapped:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OIDC_ROLE_MAPPING", tt.mapping)
+			t.Setenv("OIDC_DEFAULT_ROLE", tt.defaultRole)
+			t.Setenv("OIDC_GROUPS_CLAIM", tt.groupsClaim)
+
+			role, mapped := oidcRole(oidc.Claims{Subject: "user-1", Raw: tt.claims})
+			if role != tt.wantRole || mapped != tt.wantMapped {
+				t.Fatalf("oidcRole() = %q, %v, want %q, %v", role, mapped, tt.wantRole, tt.wantMapped)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"chewawi_web/src/models"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ceremonyLifetime is how long a WebAuthn challenge can be answered
+const ceremonyLifetime = 5 * time.Minute
+
+// webAuthn is the relying party configuration for passkeys
+var webAuthn = newWebAuthn()
+
+// ceremonies holds the pending WebAuthn challenges, keyed by a random ceremony ID.
+// Each challenge can only be answered once.
+var ceremonies = struct {
+	sync.Mutex
+	sessions map[string]ceremony
+}{sessions: make(map[string]ceremony)}
+
+// ceremony is a pending registration or login challenge
+type ceremony struct {
+	session webauthn.SessionData
+	userID  int
+	expires time.Time
+}
+
+// passkeyUser adapts a user and their passkeys to the WebAuthn library
+type passkeyUser struct {
+	user     models.User
+	passkeys []models.Passkey
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	return passkeyUserHandle(u.user.ID)
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Name()
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.passkeys))
+	for _, passkey := range u.passkeys {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(passkey.Data, &credential); err != nil {
+			log.Printf("Error decoding passkey %d: %v", passkey.ID, err)
+			continue
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials
+}
+
+// passkeyUserHandle returns the opaque WebAuthn user handle of a user ID
+func passkeyUserHandle(userID int) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// loadPasskeyUser loads a user together with their passkeys
+func loadPasskeyUser(user models.User) (passkeyUser, error) {
+	passkeys, err := passkeyStorage.GetPasskeysByUser(user.ID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	return passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+// BeginPasskeyRegistration starts registering a new passkey for the user.
+// It returns the options for navigator.credentials.create and the ceremony ID to finish with.
+func BeginPasskeyRegistration(user models.User) (*protocol.CredentialCreation, string, error) {
+	account, err := loadPasskeyUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Exclude registered passkeys so the same authenticator isn't added twice
+	creation, session, err := webAuthn.BeginRegistration(account,
+		webauthn.WithExclusions(webauthn.Credentials(account.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, err := storeCeremony(*session, user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, id, nil
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response to a registration
+// challenge and stores the new passkey under the given name
+func FinishPasskeyRegistration(user models.User, ceremonyID, name string, r *http.Request) error {
+	pending, ok := takeCeremony(ceremonyID)
+	if !ok || pending.userID != user.ID {
+		return errors.New("unknown or expired passkey registration")
+	}
+
+	account, err := loadPasskeyUser(user)
+	if err != nil {
+		return err
+	}
+
+	credential, err := webAuthn.FinishRegistration(account, pending.session, r)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	return passkeyStorage.CreatePasskey(user.ID, name, credential.ID, data, int64(credential.Authenticator.SignCount))
+}
+
+// BeginPasskeyLogin starts a passkey sign-in for any user with a discoverable credential.
+// It returns the options for navigator.credentials.get and the ceremony ID to finish with.
+func BeginPasskeyLogin() (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, err := storeCeremony(*session, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, id, nil
+}
+
+// FinishPasskeyLogin verifies the authenticator's response to a login challenge and
+// returns the user it belongs to. Since user verification is required, a passkey
+// counts as both factors and no TOTP code is asked for. When the response names a
+// user but fails verification, that user is returned with the error so the failure
+// can be counted against them.
+func FinishPasskeyLogin(ceremonyID string, r *http.Request) (models.User, error) {
+	pending, ok := takeCeremony(ceremonyID)
+	if !ok {
+		return models.User{}, errors.New("unknown or expired passkey login")
+	}
+
+	// Look up the user from the user handle the authenticator returned
+	var account passkeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, errors.New("invalid user handle")
+		}
+
+		user, err := passkeyStorage.GetUserByID(int(binary.BigEndian.Uint64(userHandle)))
+		if err != nil {
+			return nil, err
+		}
+
+		account, err = loadPasskeyUser(user)
+		if err != nil {
+			return nil, err
+		}
+		return account, nil
+	}
+
+	credential, err := webAuthn.FinishDiscoverableLogin(handler, pending.session, r)
+	if err != nil {
+		return account.user, err
+	}
+
+	// A signature counter that didn't increase means the credential may have been cloned
+	if credential.Authenticator.CloneWarning {
+		return account.user, errors.New("passkey signature counter did not increase")
+	}
+
+	// Store the new signature counter
+	for _, passkey := range account.passkeys {
+		if !bytes.Equal(passkey.CredentialID, credential.ID) {
+			continue
+		}
+
+		data, err := json.Marshal(credential)
+		if err != nil {
+			return account.user, err
+		}
+		if err := passkeyStorage.UpdatePasskeyUsage(passkey.ID, data, int64(credential.Authenticator.SignCount)); err != nil {
+			return account.user, err
+		}
+		return account.user, nil
+	}
+
+	return account.user, errors.New("passkey not found")
+}
+
+// storeCeremony remembers a pending challenge and returns its ceremony ID
+func storeCeremony(session webauthn.SessionData, userID int) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+
+	ceremonies.Lock()
+	defer ceremonies.Unlock()
+
+	// Drop expired challenges while we're here
+	now := time.Now()
+	for key, pending := range ceremonies.sessions {
+		if now.After(pending.expires) {
+			delete(ceremonies.sessions, key)
+		}
+	}
+
+	ceremonies.sessions[id] = ceremony{session: session, userID: userID, expires: now.Add(ceremonyLifetime)}
+	return id, nil
+}
+
+// takeCeremony removes and returns a pending challenge if it hasn't expired
+func takeCeremony(id string) (ceremony, bool) {
+	ceremonies.Lock()
+	defer ceremonies.Unlock()
+
+	pending, ok := ceremonies.sessions[id]
+	delete(ceremonies.sessions, id)
+	if !ok || time.Now().After(pending.expires) {
+		return ceremony{}, false
+	}
+	return pending, true
+}
+
+// newWebAuthn creates the relying party configuration from the environment
+func newWebAuthn() *webauthn.WebAuthn {
+	w, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: "Chewawi",
+		RPID:          getEnv("WEBAUTHN_RP_ID", "localhost"),
+		RPOrigins:     strings.Split(getEnv("WEBAUTHN_RP_ORIGINS", "http://localhost:8081"), ","),
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			UserVerification: protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyLifetime},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyLifetime},
+		},
+	})
+	if err != nil {
+		log.Fatalf("Invalid WebAuthn configuration: %v", err)
+	}
+	return w
+}
@@ -0,0 +1,356 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chewawi_web/src/models"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+// fakePasskeyStore keeps users and passkeys in memory
+type fakePasskeyStore struct {
+	users    map[int]models.User
+	passkeys []models.Passkey
+}
+
+func (s *fakePasskeyStore) GetUserByID(id int) (models.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return models.User{}, models.ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *fakePasskeyStore) GetPasskeysByUser(userID int) ([]models.Passkey, error) {
+	var passkeys []models.Passkey
+	for _, passkey := range s.passkeys {
+		if passkey.UserID == userID {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	return passkeys, nil
+}
+
+func (s *fakePasskeyStore) CreatePasskey(userID int, name string, credentialID, data []byte, signCount int64) error {
+	s.passkeys = append(s.passkeys, models.Passkey{
+		ID:           len(s.passkeys) + 1,
+		UserID:       userID,
+		Name:         name,
+		CredentialID: credentialID,
+		Data:         data,
+		SignCount:    signCount,
+	})
+	return nil
+}
+
+func (s *fakePasskeyStore) UpdatePasskeyUsage(id int, data []byte, signCount int64) error {
+	for i := range s.passkeys {
+		if s.passkeys[i].ID == id {
+			s.passkeys[i].Data = data
+			s.passkeys[i].SignCount = signCount
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+// useFakePasskeyStore swaps the passkey storage for an in-memory one holding the user
+func useFakePasskeyStore(t *testing.T, user models.User) *fakePasskeyStore {
+	t.Helper()
+	store := &fakePasskeyStore{users: map[int]models.User{user.ID: user}}
+	previous := passkeyStorage
+	passkeyStorage = store
+	t.Cleanup(func() { passkeyStorage = previous })
+	return store
+}
+
+// softwareAuthenticator is a passkey authenticator holding a single P-256 credential
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softwareAuthenticator{key: key, credentialID: credentialID}
+}
+
+// authenticatorData builds the authenticator data for the configured relying party.
+// User presence and verification are always asserted.
+func (a *softwareAuthenticator) authenticatorData(t *testing.T, attested bool) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if !attested {
+		return data
+	}
+
+	publicKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, publicKey...)
+}
+
+// clientData builds the client data JSON a browser would send for the challenge
+func clientData(t *testing.T, ceremonyType, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{
+		"type":        ceremonyType,
+		"challenge":   challenge,
+		"origin":      "http://localhost:8081",
+		"crossOrigin": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create answers a registration challenge the way navigator.credentials.create would
+func (a *softwareAuthenticator) create(t *testing.T, creation *protocol.CredentialCreation) *http.Request {
+	t.Helper()
+	a.userHandle = creation.Response.User.ID.(protocol.URLEncodedBase64)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentialRequest(t, a.credentialID, map[string]string{
+		"clientDataJSON":    encode(clientData(t, "webauthn.create", creation.Response.Challenge.String())),
+		"attestationObject": encode(attestation),
+	})
+}
+
+// get answers a login challenge the way navigator.credentials.get would
+func (a *softwareAuthenticator) get(t *testing.T, assertion *protocol.CredentialAssertion) *http.Request {
+	t.Helper()
+	authData := a.authenticatorData(t, false)
+	client := clientData(t, "webauthn.get", assertion.Response.Challenge.String())
+
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentialRequest(t, a.credentialID, map[string]string{
+		"clientDataJSON":    encode(client),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+// credentialRequest wraps an authenticator response in a PublicKeyCredential request body
+func credentialRequest(t *testing.T, credentialID []byte, response map[string]string) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"id":       encode(credentialID),
+		"rawId":    encode(credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// registerPasskey registers the authenticator's credential for the user
+func registerPasskey(t *testing.T, user models.User, authenticator *softwareAuthenticator) {
+	t.Helper()
+	creation, id, err := BeginPasskeyRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	if err := FinishPasskeyRegistration(user, id, " Laptop ", authenticator.create(t, creation)); err != nil {
+		t.Fatalf("FinishPasskeyRegistration: %v", err)
+	}
+}
+
+var passkeyTestUser = models.User{ID: 7, Username: "alice", Role: models.RoleAuthor}
+
+func TestPasskeyRegistrationAndDiscoverableLogin(t *testing.T) {
+	store := useFakePasskeyStore(t, passkeyTestUser)
+	authenticator := newSoftwareAuthenticator(t)
+	authenticator.signCount = 1
+
+	registerPasskey(t, passkeyTestUser, authenticator)
+	if len(store.passkeys) != 1 {
+		t.Fatalf("stored %d passkeys, want 1", len(store.passkeys))
+	}
+	passkey := store.passkeys[0]
+	if passkey.Name != "Laptop" || !bytes.Equal(passkey.CredentialID, authenticator.credentialID) || passkey.SignCount != 1 {
+		t.Fatalf("stored passkey = %+v", passkey)
+	}
+
+	for _, count := range []uint32{2, 5} {
+		authenticator.signCount = count
+		assertion, id, err := BeginPasskeyLogin()
+		if err != nil {
+			t.Fatalf("BeginPasskeyLogin: %v", err)
+		}
+		user, err := FinishPasskeyLogin(id, authenticator.get(t, assertion))
+		if err != nil {
+			t.Fatalf("FinishPasskeyLogin with counter %d: %v", count, err)
+		}
+		if user.ID != passkeyTestUser.ID {
+			t.Fatalf("logged in as user %d, want %d", user.ID, passkeyTestUser.ID)
+		}
+		if got := store.passkeys[0].SignCount; got != int64(count) {
+			t.Fatalf("stored sign count = %d, want %d", got, count)
+		}
+	}
+}
+
+func TestPasskeyLoginRejectsSignCountRegression(t *testing.T) {
+	store := useFakePasskeyStore(t, passkeyTestUser)
+	authenticator := newSoftwareAuthenticator(t)
+	authenticator.signCount = 10
+	registerPasskey(t, passkeyTestUser, authenticator)
+
+	// A clone replaying the same or an older counter must be refused
+	for _, count := range []uint32{10, 3} {
+		authenticator.signCount = count
+		assertion, id, err := BeginPasskeyLogin()
+		if err != nil {
+			t.Fatalf("BeginPasskeyLogin: %v", err)
+		}
+		user, err := FinishPasskeyLogin(id, authenticator.get(t, assertion))
+		if err == nil {
+			t.Fatalf("login with counter %d after 10 succeeded", count)
+		}
+		// The failure is reported against the account so it counts towards its lockout
+		if user.Username != passkeyTestUser.Username {
+			t.Fatalf("failed login returned user %q, want %q", user.Username, passkeyTestUser.Username)
+		}
+		if got := store.passkeys[0].SignCount; got != 10 {
+			t.Fatalf("stored sign count = %d after rejected login, want 10", got)
+		}
+	}
+}
+
+func TestPasskeyLoginRejectsReplayedCeremony(t *testing.T) {
+	useFakePasskeyStore(t, passkeyTestUser)
+	authenticator := newSoftwareAuthenticator(t)
+	registerPasskey(t, passkeyTestUser, authenticator)
+
+	assertion, id, err := BeginPasskeyLogin()
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	authenticator.signCount = 1
+	if _, err := FinishPasskeyLogin(id, authenticator.get(t, assertion)); err != nil {
+		t.Fatalf("FinishPasskeyLogin: %v", err)
+	}
+
+	// Answering the same challenge again must fail even with a valid signature
+	authenticator.signCount = 2
+	if _, err := FinishPasskeyLogin(id, authenticator.get(t, assertion)); err == nil {
+		t.Fatal("replayed login ceremony succeeded")
+	}
+}
+
+func TestPasskeyRejectsExpiredCeremony(t *testing.T) {
+	store := useFakePasskeyStore(t, passkeyTestUser)
+	authenticator := newSoftwareAuthenticator(t)
+
+	expire := func(id string) {
+		ceremonies.Lock()
+		defer ceremonies.Unlock()
+		pending := ceremonies.sessions[id]
+		pending.expires = time.Now().Add(-time.Second)
+		ceremonies.sessions[id] = pending
+	}
+
+	creation, id, err := BeginPasskeyRegistration(passkeyTestUser)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	expire(id)
+	if err := FinishPasskeyRegistration(passkeyTestUser, id, "Laptop", authenticator.create(t, creation)); err == nil {
+		t.Fatal("expired registration ceremony succeeded")
+	}
+	if len(store.passkeys) != 0 {
+		t.Fatalf("stored %d passkeys after expired registration", len(store.passkeys))
+	}
+
+	registerPasskey(t, passkeyTestUser, authenticator)
+	assertion, id, err := BeginPasskeyLogin()
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	expire(id)
+	authenticator.signCount = 1
+	if _, err := FinishPasskeyLogin(id, authenticator.get(t, assertion)); err == nil {
+		t.Fatal("expired login ceremony succeeded")
+	}
+}
+
+func TestPasskeyRegistrationRejectsOtherUsersCeremony(t *testing.T) {
+	useFakePasskeyStore(t, passkeyTestUser)
+	authenticator := newSoftwareAuthenticator(t)
+
+	creation, id, err := BeginPasskeyRegistration(passkeyTestUser)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	other := models.User{ID: 8, Username: "mallory"}
+	if err := FinishPasskeyRegistration(other, id, "Laptop", authenticator.create(t, creation)); err == nil {
+		t.Fatal("finished another user's registration ceremony")
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+
+	"chewawi_web/src/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt cost used for new password hashes.
+// Stored hashes with a different cost are rehashed on the next successful login.
+var passwordCost = getPasswordCost()
+
+// dummyPasswordHash is compared against when a username does not exist,
+// so unknown users take as long to reject as wrong passwords
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), passwordCost)
+
+// HashPassword hashes a password with the configured bcrypt cost
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password cannot be empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Authenticate checks if the provided username and password are valid and returns the user
+func Authenticate(username, password string) (models.User, bool) {
+	user, hash, err := models.GetUserCredentials(username)
+	if err != nil || hash == "" {
+		// Spend the same time as a real comparison before rejecting
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return models.User{}, false
+	}
+
+	// CompareHashAndPassword compares in constant time
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return models.User{}, false
+	}
+
+	// Rehash when the cost settings changed since the password was stored
+	if cost, err := bcrypt.Cost([]byte(hash)); err == nil && cost != passwordCost {
+		if newHash, err := HashPassword(password); err != nil {
+			log.Printf("Error rehashing password: %v", err)
+		} else if err := models.SetUserPassword(user.ID, newHash); err != nil {
+			log.Printf("Error storing rehashed password: %v", err)
+		}
+	}
+
+	return user, true
+}
+
+// defaultAdminPasswords are the well-known passwords the admin's first password must
+// never be set from in production
+var defaultAdminPasswords = []string{"password"}
+
+// BootstrapAdminPassword sets the env-configured admin's password from ADMIN_PASSWORD
+// if the admin has no password yet, so a fresh install can still log in. Without
+// ADMIN_PASSWORD nothing is set and the operator sets it with the passwd command. In
+// production (APP_ENV=production) it refuses to start until the admin has a password,
+// and refuses to set a well-known one.
+func BootstrapAdminPassword() error {
+	user, hash, err := models.GetUserCredentials(adminUser)
+	if err != nil {
+		return err
+	}
+	if hash != "" {
+		return nil
+	}
+
+	production := os.Getenv("APP_ENV") == "production"
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		if production {
+			return fmt.Errorf("%s has no password and ADMIN_PASSWORD is not set; run `%s passwd %s` to set one", adminUser, os.Args[0], adminUser)
+		}
+		log.Printf("Warning: %s has no password and ADMIN_PASSWORD is not set; run `%s passwd %s` to set one", adminUser, os.Args[0], adminUser)
+		return nil
+	}
+	if production && slices.Contains(defaultAdminPasswords, password) {
+		return errors.New("ADMIN_PASSWORD uses a well-known value; refusing to set it in production")
+	}
+
+	hash, err = HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Setting password for %s from ADMIN_PASSWORD", adminUser)
+	return models.SetUserPassword(user.ID, hash)
+}
+
+// getPasswordCost reads the bcrypt cost from BCRYPT_COST
+func getPasswordCost() int {
+	cost, err := strconv.Atoi(getEnv("BCRYPT_COST", "12"))
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		log.Printf("Warning: invalid BCRYPT_COST, using %d", bcrypt.DefaultCost)
+		return bcrypt.DefaultCost
+	}
+	return cost
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultLoginRedirect is where users land after signing in without a page to return to
+const defaultLoginRedirect = "/owner"
+
+// LoginOptions are the choices made on the login page that have to survive the
+// later steps of the login, such as the second factor or the identity provider
+type LoginOptions struct {
+	// Remember asks for a long-lived session
+	Remember bool
+	// Next is the page to return to after signing in, see SafeRedirectPath
+	Next string
+}
+
+// Redirect returns where to send the user once they're signed in
+func (o LoginOptions) Redirect() string {
+	if next := SafeRedirectPath(o.Next); next != "" {
+		return next
+	}
+	return defaultLoginRedirect
+}
+
+// SafeRedirectPath returns next if it's a path on this site that's safe to
+// redirect to after signing in, or "" otherwise. Only relative paths are
+// accepted so the login page can't be used to send users to another site.
+func SafeRedirectPath(next string) string {
+	// Must be an absolute path, not a scheme-relative URL like //evil.example
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return ""
+	}
+
+	// Browsers treat backslashes as slashes and drop tabs and newlines, which
+	// would turn /\evil.example into another host
+	if strings.Contains(next, `\`) || strings.IndexFunc(next, isControl) >= 0 {
+		return ""
+	}
+
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return ""
+	}
+
+	// Returning to the login itself would only go round in circles
+	if u.Path == "/login" || strings.HasPrefix(u.Path, "/login/") || u.Path == "/logout" {
+		return ""
+	}
+
+	return next
+}
+
+// LoginURL returns the login page URL that comes back to the requested page
+// afterwards. Only pages that can be fetched again are returned to.
+func LoginURL(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "/login"
+	}
+
+	next := SafeRedirectPath(r.URL.RequestURI())
+	if next == "" {
+		return "/login"
+	}
+	return "/login?next=" + url.QueryEscape(next)
+}
+
+// isControl reports whether r is an ASCII control character
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
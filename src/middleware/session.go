@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chewawi_web/src/models"
+)
+
+// accessTokenLifetime is how long an access token is valid before it has to be renewed
+var accessTokenLifetime = getEnvDuration("ACCESS_TOKEN_LIFETIME", 15*time.Minute)
+
+// sessionLifetime is the absolute lifetime of a session, however active it is
+var sessionLifetime = getEnvDuration("SESSION_LIFETIME", 12*time.Hour)
+
+// rememberedSessionLifetime is the absolute lifetime of a session signed in with "remember me"
+var rememberedSessionLifetime = getEnvDuration("REMEMBER_ME_LIFETIME", 30*24*time.Hour)
+
+// refreshCookie holds the session's refresh token
+const refreshCookie = "refresh"
+
+// refreshReuseGrace is how long a just rotated refresh token is still accepted,
+// for concurrent requests that were sent with it
+const refreshReuseGrace = 10 * time.Second
+
+// sessionTouchInterval is how often a session's last use is written back, so busy
+// sessions don't cost an UPDATE on every request
+const sessionTouchInterval = time.Minute
+
+// maxUserAgentLength is the longest user agent stored with a session
+const maxUserAgentLength = 512
+
+// StartSession records a new session for the user signing in with the request and
+// sets its access and refresh token cookies. Remembered sessions survive closing
+// the browser and last longer.
+func StartSession(w http.ResponseWriter, r *http.Request, user models.User, remember bool) error {
+	id := randomToken()
+	secret := randomToken()
+
+	lifetime := sessionLifetime
+	if remember {
+		lifetime = rememberedSessionLifetime
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	err := models.CreateSession(id, user.ID, userAgent, ClientIP(r), hashRefreshToken(secret), remember, lifetime)
+	if err != nil {
+		return err
+	}
+
+	return setSessionCookies(w, user, models.Session{ID: id, Remember: remember}, secret)
+}
+
+// SessionFromRequest returns the signed-in user and their session from the request's
+// access token, renewing an expired access token with the refresh token on the way
+func SessionFromRequest(w http.ResponseWriter, r *http.Request) (models.User, models.Session, error) {
+	// Use the access token while it's valid
+	if cookie, err := r.Cookie("token"); err == nil {
+		claims := &Claims{}
+		token, err := ParseToken(cookie.Value, claims)
+		if err == nil && token.Valid {
+			return loadSession(r, claims.ID, claims.Username)
+		}
+	}
+
+	// Otherwise renew it with the refresh token
+	return refreshSession(w, r)
+}
+
+// loadSession loads an active session and its user
+func loadSession(r *http.Request, id, username string) (models.User, models.Session, error) {
+	// Make sure the session is still active
+	session, err := models.GetActiveSession(id)
+	if err != nil {
+		return models.User{}, models.Session{}, err
+	}
+
+	user, err := models.GetUserByID(session.UserID)
+	if err != nil {
+		return models.User{}, models.Session{}, err
+	}
+	if username != "" && user.Username != username {
+		return models.User{}, models.Session{}, errors.New("session token does not match its session")
+	}
+
+	if time.Since(session.LastSeenAt) >= sessionTouchInterval {
+		if err := models.TouchSession(session.ID, ClientIP(r)); err != nil {
+			log.Printf("Error updating session: %v", err)
+		}
+	}
+
+	return user, session, nil
+}
+
+// refreshSession rotates the request's refresh token and issues a new access token.
+// A refresh token that was already rotated revokes the whole session, as it can only
+// be presented again if it was stolen.
+func refreshSession(w http.ResponseWriter, r *http.Request) (models.User, models.Session, error) {
+	id, secret, err := refreshTokenFromRequest(r)
+	if err != nil {
+		return models.User{}, models.Session{}, err
+	}
+
+	user, session, err := loadSession(r, id, "")
+	if err != nil {
+		return models.User{}, models.Session{}, err
+	}
+
+	// Swap the refresh token for a new one
+	hash := hashRefreshToken(secret)
+	newSecret := randomToken()
+	rotated, err := models.RotateRefreshToken(id, hash, hashRefreshToken(newSecret))
+	if err != nil {
+		return models.User{}, models.Session{}, err
+	}
+
+	if !rotated {
+		matched, recent, err := models.CheckPreviousRefreshToken(id, hash, refreshReuseGrace)
+		if err != nil {
+			return models.User{}, models.Session{}, err
+		}
+		if !matched {
+			return models.User{}, models.Session{}, errors.New("invalid refresh token")
+		}
+		if !recent {
+			log.Printf("Refresh token reuse detected for a session of %s, revoking it", user.Username)
+			if err := models.DeleteSession(id); err != nil {
+				log.Printf("Error revoking session: %v", err)
+			}
+			return models.User{}, models.Session{}, errors.New("refresh token reused")
+		}
+
+		// A concurrent request already rotated it; keep the refresh cookie that one set
+		newSecret = ""
+	}
+
+	if err := setSessionCookies(w, user, session, newSecret); err != nil {
+		return models.User{}, models.Session{}, err
+	}
+
+	return user, session, nil
+}
+
+// EndSession revokes the session of the request, if it has one
+func EndSession(r *http.Request) error {
+	// Find the session from the access token if it's still valid
+	if cookie, err := r.Cookie("token"); err == nil {
+		claims := &Claims{}
+		token, err := ParseToken(cookie.Value, claims)
+		if err == nil && token.Valid {
+			return models.DeleteSession(claims.ID)
+		}
+	}
+
+	// Or from the refresh token
+	id, secret, err := refreshTokenFromRequest(r)
+	if err != nil {
+		return nil
+	}
+	return models.DeleteSessionByRefreshToken(id, hashRefreshToken(secret))
+}
+
+// ClearSessionCookies removes the access and refresh token cookies
+func ClearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{"token", refreshCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Expires:  time.Now().Add(-1 * time.Hour),
+			HttpOnly: true,
+			Path:     "/",
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// setSessionCookies issues a new access token for the session and sets it as a cookie,
+// along with the refresh token unless it's empty
+func setSessionCookies(w http.ResponseWriter, user models.User, session models.Session, refreshSecret string) error {
+	token, err := GenerateToken(user.Username, session.ID)
+	if err != nil {
+		return err
+	}
+
+	// Remembered sessions outlive the browser, others end with it
+	var expires time.Time
+	if session.Remember {
+		expires = time.Now().Add(rememberedSessionLifetime)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    token,
+		Expires:  expires,
+		HttpOnly: true,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if refreshSecret != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshCookie,
+			Value:    session.ID + "." + refreshSecret,
+			Expires:  expires,
+			HttpOnly: true,
+			Path:     "/",
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	return nil
+}
+
+// refreshTokenFromRequest splits the refresh token cookie into its session ID and secret
+func refreshTokenFromRequest(r *http.Request) (string, string, error) {
+	cookie, err := r.Cookie(refreshCookie)
+	if err != nil {
+		return "", "", err
+	}
+
+	id, secret, ok := strings.Cut(cookie.Value, ".")
+	if !ok || id == "" || secret == "" {
+		return "", "", errors.New("malformed refresh token")
+	}
+	return id, secret, nil
+}
+
+// hashRefreshToken hashes a refresh token secret for storage
+func hashRefreshToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns a random, URL-safe token with 256 bits of entropy
+func randomToken() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
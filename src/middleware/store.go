@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"time"
+
+	"chewawi_web/src/models"
+)
+
+// passkeyStore is the storage the passkey ceremonies need, so tests can run them without a database
+type passkeyStore interface {
+	GetUserByID(id int) (models.User, error)
+	GetPasskeysByUser(userID int) ([]models.Passkey, error)
+	CreatePasskey(userID int, name string, credentialID, data []byte, signCount int64) error
+	UpdatePasskeyUsage(id int, data []byte, signCount int64) error
+}
+
+// passkeyStorage is where passkeys are loaded from and saved to
+var passkeyStorage passkeyStore = modelStore{}
+
+// userTokenStore is the storage single-use user tokens need, so tests can run them without a database
+type userTokenStore interface {
+	GetUserByID(id int) (models.User, error)
+	CreateUserToken(userID int, purpose models.TokenPurpose, hash string, lifetime time.Duration) error
+	GetUserByToken(purpose models.TokenPurpose, hash string) (models.User, error)
+	UseUserToken(purpose models.TokenPurpose, hash string) (int, error)
+	SetUserPassword(id int, hash string) error
+	DeleteUserSessions(userID int, keepID string) error
+}
+
+// userTokenStorage is where user tokens are stored and redeemed
+var userTokenStorage userTokenStore = modelStore{}
+
+// loginAttemptStore is the storage the login throttle needs, so tests can run it without a database
+type loginAttemptStore interface {
+	RecordLoginAttempt(username, ip string, succeeded bool) error
+	RecentLoginFailuresByUsername(username string, window time.Duration) (int, time.Duration, error)
+	RecentLoginFailuresByIP(ip string, window time.Duration) (int, time.Duration, error)
+}
+
+// loginAttemptStorage is where sign-in attempts are recorded and counted
+var loginAttemptStorage loginAttemptStore = modelStore{}
+
+// modelStore stores everything in the database through the models package
+type modelStore struct{}
+
+func (modelStore) GetUserByID(id int) (models.User, error) {
+	return models.GetUserByID(id)
+}
+
+func (modelStore) GetPasskeysByUser(userID int) ([]models.Passkey, error) {
+	return models.GetPasskeysByUser(userID)
+}
+
+func (modelStore) CreatePasskey(userID int, name string, credentialID, data []byte, signCount int64) error {
+	return models.CreatePasskey(userID, name, credentialID, data, signCount)
+}
+
+func (modelStore) UpdatePasskeyUsage(id int, data []byte, signCount int64) error {
+	return models.UpdatePasskeyUsage(id, data, signCount)
+}
+
+func (modelStore) CreateUserToken(userID int, purpose models.TokenPurpose, hash string, lifetime time.Duration) error {
+	return models.CreateUserToken(userID, purpose, hash, lifetime)
+}
+
+func (modelStore) GetUserByToken(purpose models.TokenPurpose, hash string) (models.User, error) {
+	return models.GetUserByToken(purpose, hash)
+}
+
+func (modelStore) UseUserToken(purpose models.TokenPurpose, hash string) (int, error) {
+	return models.UseUserToken(purpose, hash)
+}
+
+func (modelStore) SetUserPassword(id int, hash string) error {
+	return models.SetUserPassword(id, hash)
+}
+
+func (modelStore) DeleteUserSessions(userID int, keepID string) error {
+	return models.DeleteUserSessions(userID, keepID)
+}
+
+func (modelStore) RecordLoginAttempt(username, ip string, succeeded bool) error {
+	return models.RecordLoginAttempt(username, ip, succeeded)
+}
+
+func (modelStore) RecentLoginFailuresByUsername(username string, window time.Duration) (int, time.Duration, error) {
+	return models.RecentLoginFailuresByUsername(username, window)
+}
+
+func (modelStore) RecentLoginFailuresByIP(ip string, window time.Duration) (int, time.Duration, error) {
+	return models.RecentLoginFailuresByIP(ip, window)
+}
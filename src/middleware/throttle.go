@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// loginFreeAttempts is how many failures are allowed before backing off
+	loginFreeAttempts = 3
+	// loginBackoffBase is the delay after the first failure past the free attempts, doubled after each further one
+	loginBackoffBase = time.Second
+	// loginBackoffMax caps the backoff delay before the lockout kicks in
+	loginBackoffMax = 5 * time.Minute
+	// loginIPLockoutFactor lets an IP, which may be shared, fail this many times more than a username
+	loginIPLockoutFactor = 5
+)
+
+// loginLockoutThreshold is how many failures lock a username out
+var loginLockoutThreshold = getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10)
+
+// loginLockoutDuration is how long a lockout lasts, and the window failures are counted in
+var loginLockoutDuration = getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+
+// LoginThrottledError is returned when a sign-in attempt must wait before being tried
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed sign-in attempts, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// loginPendingTimeout is how long an attempt that passed the throttle counts as a
+// failure when its result is never recorded
+const loginPendingTimeout = time.Minute
+
+// loginPending holds the start of the attempts that passed the throttle and have no
+// result yet, by "user:" and "ip:" key. They count as failures until recorded, so
+// parallel attempts can't all pass the check before any of them fails.
+var loginPending = struct {
+	sync.Mutex
+	started map[string][]time.Time
+}{started: make(map[string][]time.Time)}
+
+// CheckLoginThrottle reports whether a sign-in attempt for the username from the IP
+// may go ahead, returning a *LoginThrottledError if it has to wait. An attempt that
+// may go ahead is reserved in the same step, until RecordLoginResult or
+// ReleaseLoginAttempt ends it. An empty username only checks the IP, for passkey
+// sign-ins that don't name an account up front, and an empty IP only the username.
+func CheckLoginThrottle(username, ip string) error {
+	loginPending.Lock()
+	defer loginPending.Unlock()
+
+	var wait time.Duration
+	if username != "" {
+		failures, since, err := loginAttemptStorage.RecentLoginFailuresByUsername(username, loginLockoutDuration)
+		if err != nil {
+			return err
+		}
+		failures, since = withPendingAttempts("user:"+username, failures, since)
+		wait = max(wait, loginWait(failures, since, loginLockoutThreshold))
+	}
+	if ip != "" {
+		failures, since, err := loginAttemptStorage.RecentLoginFailuresByIP(ip, loginLockoutDuration)
+		if err != nil {
+			return err
+		}
+		failures, since = withPendingAttempts("ip:"+ip, failures, since)
+		wait = max(wait, loginWait(failures, since, loginLockoutThreshold*loginIPLockoutFactor))
+	}
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+
+	// Reserve the attempt
+	now := time.Now()
+	for _, key := range loginPendingKeys(username, ip) {
+		loginPending.started[key] = append(loginPending.started[key], now)
+	}
+	return nil
+}
+
+// RecordLoginResult logs a sign-in attempt so later ones can be throttled, ending
+// its reservation
+func RecordLoginResult(username, ip string, succeeded bool) {
+	if err := loginAttemptStorage.RecordLoginAttempt(username, ip, succeeded); err != nil {
+		log.Printf("Error recording login attempt: %v", err)
+	}
+	ReleaseLoginAttempt(username, ip)
+}
+
+// ReleaseLoginAttempt ends the reservation of an attempt without recording a
+// result, such as when the password was right but a second factor is still to come
+func ReleaseLoginAttempt(username, ip string) {
+	loginPending.Lock()
+	defer loginPending.Unlock()
+
+	for _, key := range loginPendingKeys(username, ip) {
+		if started := loginPending.started[key]; len(started) > 1 {
+			loginPending.started[key] = started[1:]
+		} else {
+			delete(loginPending.started, key)
+		}
+	}
+}
+
+// loginPendingKeys returns the keys attempts for the username from the IP are
+// reserved under
+func loginPendingKeys(username, ip string) []string {
+	var keys []string
+	if username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// withPendingAttempts adds the attempts still in progress for the key to the
+// recorded failures, as if they had just failed. loginPending must be locked.
+func withPendingAttempts(key string, failures int, sinceLast time.Duration) (int, time.Duration) {
+	started := loginPending.started[key]
+	for len(started) > 0 && time.Since(started[0]) > loginPendingTimeout {
+		started = started[1:]
+	}
+	if len(started) == 0 {
+		delete(loginPending.started, key)
+		return failures, sinceLast
+	}
+	loginPending.started[key] = started
+	return failures + len(started), 0
+}
+
+// loginWait returns how long to wait after the given number of failures,
+// the last of which happened the given time ago
+func loginWait(failures int, sinceLast time.Duration, threshold int) time.Duration {
+	if failures < loginFreeAttempts {
+		return 0
+	}
+
+	// Lock out entirely after too many failures
+	delay := loginLockoutDuration
+	if failures < threshold {
+		// Otherwise back off exponentially
+		delay = loginBackoffBase << min(failures-loginFreeAttempts, 20)
+		delay = min(delay, loginBackoffMax)
+	}
+
+	return max(delay-sinceLast, 0)
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLoginAttemptStore counts failed attempts in memory. Failures set up by a test
+// happened an hour ago, recorded ones just now.
+type fakeLoginAttemptStore struct {
+	mu         sync.Mutex
+	byUsername map[string]int
+	byIP       map[string]int
+	lastFailed map[string]time.Time
+	// delay slows down counting, so parallel checks overlap
+	delay time.Duration
+}
+
+func (s *fakeLoginAttemptStore) RecordLoginAttempt(username, ip string, succeeded bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !succeeded {
+		s.byUsername[username]++
+		s.byIP[ip]++
+		s.lastFailed["user:"+username] = time.Now()
+		s.lastFailed["ip:"+ip] = time.Now()
+	}
+	return nil
+}
+
+func (s *fakeLoginAttemptStore) RecentLoginFailuresByUsername(username string, window time.Duration) (int, time.Duration, error) {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.byUsername[username], s.sinceLastFailure("user:" + username), nil
+}
+
+func (s *fakeLoginAttemptStore) RecentLoginFailuresByIP(ip string, window time.Duration) (int, time.Duration, error) {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.byIP[ip], s.sinceLastFailure("ip:" + ip), nil
+}
+
+func (s *fakeLoginAttemptStore) sinceLastFailure(key string) time.Duration {
+	if last, ok := s.lastFailed[key]; ok {
+		return time.Since(last)
+	}
+	return time.Hour
+}
+
+// useFakeLoginAttemptStore swaps the login attempt storage for an in-memory one
+func useFakeLoginAttemptStore(t *testing.T) *fakeLoginAttemptStore {
+	t.Helper()
+	store := &fakeLoginAttemptStore{
+		byUsername: make(map[string]int),
+		byIP:       make(map[string]int),
+		lastFailed: make(map[string]time.Time),
+	}
+	previous := loginAttemptStorage
+	loginAttemptStorage = store
+	t.Cleanup(func() {
+		loginAttemptStorage = previous
+		loginPending.Lock()
+		clear(loginPending.started)
+		loginPending.Unlock()
+	})
+	return store
+}
+
+func TestCheckLoginThrottleReservesParallelAttempts(t *testing.T) {
+	store := useFakeLoginAttemptStore(t)
+	store.delay = time.Millisecond
+
+	// One failure short of the lockout, long enough ago that the backoff is over
+	store.byUsername["bob"] = loginLockoutThreshold - 1
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	passed := 0
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every guess comes from another address, so only the username limit applies
+			ip := "192.0.2." + string(rune('a'+i))
+			if err := CheckLoginThrottle("bob", ip); err == nil {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if passed != 1 {
+		t.Fatalf("%d parallel attempts passed the throttle, want 1", passed)
+	}
+}
+
+func TestRecordLoginResultEndsReservation(t *testing.T) {
+	store := useFakeLoginAttemptStore(t)
+	store.byUsername["bob"] = loginLockoutThreshold - 1
+
+	if err := CheckLoginThrottle("bob", "192.0.2.1"); err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+
+	// While the first attempt runs, the next one has to wait
+	var throttled *LoginThrottledError
+	if err := CheckLoginThrottle("bob", "192.0.2.2"); !errors.As(err, &throttled) {
+		t.Fatalf("attempt in parallel: err = %v, want LoginThrottledError", err)
+	}
+
+	// A success frees the reservation without counting as a failure
+	RecordLoginResult("bob", "192.0.2.1", true)
+	if err := CheckLoginThrottle("bob", "192.0.2.2"); err != nil {
+		t.Fatalf("attempt after a success: %v", err)
+	}
+
+	// A failure frees it too, and locks the username out
+	RecordLoginResult("bob", "192.0.2.2", false)
+	if err := CheckLoginThrottle("bob", "192.0.2.3"); !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("attempt after the lockout: err = %v, want LoginThrottledError", err)
+	}
+}
+
+func TestReleaseLoginAttempt(t *testing.T) {
+	store := useFakeLoginAttemptStore(t)
+	store.byIP["192.0.2.1"] = loginLockoutThreshold*loginIPLockoutFactor - 1
+
+	if err := CheckLoginThrottle("", "192.0.2.1"); err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+	ReleaseLoginAttempt("", "192.0.2.1")
+	if err := CheckLoginThrottle("", "192.0.2.1"); err != nil {
+		t.Fatalf("attempt after release: %v", err)
+	}
+	if store.byIP["192.0.2.1"] != loginLockoutThreshold*loginIPLockoutFactor-1 {
+		t.Fatal("releasing an attempt recorded it")
+	}
+}
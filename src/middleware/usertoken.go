@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"chewawi_web/src/models"
+)
+
+// passwordResetLifetime is how long a password reset link works
+var passwordResetLifetime = getEnvDuration("PASSWORD_RESET_LIFETIME", time.Hour)
+
+// inviteLifetime is how long an invitation link works
+var inviteLifetime = getEnvDuration("INVITE_LIFETIME", 7*24*time.Hour)
+
+// UserTokenLifetime returns how long tokens with the purpose work
+func UserTokenLifetime(purpose models.TokenPurpose) time.Duration {
+	if purpose == models.TokenInvite {
+		return inviteLifetime
+	}
+	return passwordResetLifetime
+}
+
+// IssueUserToken creates a single-use token with the purpose for the user and
+// returns it to be sent to them. Only its hash is stored.
+func IssueUserToken(userID int, purpose models.TokenPurpose) (string, error) {
+	token := randomToken()
+	if err := userTokenStorage.CreateUserToken(userID, purpose, hashUserToken(token), UserTokenLifetime(purpose)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UserFromToken returns the user a token was issued to if it can still be used,
+// without using it up
+func UserFromToken(purpose models.TokenPurpose, token string) (models.User, error) {
+	if token == "" {
+		return models.User{}, models.ErrInvalidUserToken
+	}
+	return userTokenStorage.GetUserByToken(purpose, hashUserToken(token))
+}
+
+// SetPasswordWithToken uses up a token to set its user's password, and signs
+// the user out everywhere in case someone else knew the old one
+func SetPasswordWithToken(purpose models.TokenPurpose, token, password string) (models.User, error) {
+	// Hash first, so a failure doesn't waste the token
+	hash, err := HashPassword(password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	userID, err := userTokenStorage.UseUserToken(purpose, hashUserToken(token))
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if err := userTokenStorage.SetUserPassword(userID, hash); err != nil {
+		return models.User{}, err
+	}
+	if err := userTokenStorage.DeleteUserSessions(userID, ""); err != nil {
+		return models.User{}, err
+	}
+
+	return userTokenStorage.GetUserByID(userID)
+}
+
+// hashUserToken hashes a single-use user token for storage and lookup
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"chewawi_web/src/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeUserTokenStore keeps users and their tokens in memory, with a clock the
+// test can move forward
+type fakeUserTokenStore struct {
+	now       time.Time
+	users     map[int]models.User
+	passwords map[int]string
+	tokens    []fakeUserToken
+	// signedOut counts the times each user was signed out everywhere
+	signedOut map[int]int
+}
+
+// fakeUserToken is a stored single-use token
+type fakeUserToken struct {
+	userID  int
+	purpose models.TokenPurpose
+	hash    string
+	expires time.Time
+	used    bool
+}
+
+func (s *fakeUserTokenStore) GetUserByID(id int) (models.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return models.User{}, models.ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *fakeUserTokenStore) CreateUserToken(userID int, purpose models.TokenPurpose, hash string, lifetime time.Duration) error {
+	// Earlier unused tokens with the same purpose stop working
+	kept := s.tokens[:0]
+	for _, token := range s.tokens {
+		if token.userID != userID || token.purpose != purpose || token.used {
+			kept = append(kept, token)
+		}
+	}
+	s.tokens = append(kept, fakeUserToken{userID: userID, purpose: purpose, hash: hash, expires: s.now.Add(lifetime)})
+	return nil
+}
+
+func (s *fakeUserTokenStore) GetUserByToken(purpose models.TokenPurpose, hash string) (models.User, error) {
+	token := s.usable(purpose, hash)
+	if token == nil {
+		return models.User{}, models.ErrInvalidUserToken
+	}
+	return s.GetUserByID(token.userID)
+}
+
+func (s *fakeUserTokenStore) UseUserToken(purpose models.TokenPurpose, hash string) (int, error) {
+	token := s.usable(purpose, hash)
+	if token == nil {
+		return 0, models.ErrInvalidUserToken
+	}
+	token.used = true
+	return token.userID, nil
+}
+
+func (s *fakeUserTokenStore) SetUserPassword(id int, hash string) error {
+	s.passwords[id] = hash
+	return nil
+}
+
+func (s *fakeUserTokenStore) DeleteUserSessions(userID int, keepID string) error {
+	s.signedOut[userID]++
+	return nil
+}
+
+// usable finds a token that is unused and hasn't expired
+func (s *fakeUserTokenStore) usable(purpose models.TokenPurpose, hash string) *fakeUserToken {
+	for i := range s.tokens {
+		token := &s.tokens[i]
+		if token.hash == hash && token.purpose == purpose && !token.used && s.now.Before(token.expires) {
+			return token
+		}
+	}
+	return nil
+}
+
+// useFakeUserTokenStore swaps the user token storage for an in-memory one holding the user
+func useFakeUserTokenStore(t *testing.T, user models.User) *fakeUserTokenStore {
+	t.Helper()
+	store := &fakeUserTokenStore{
+		now:       time.Now(),
+		users:     map[int]models.User{user.ID: user},
+		passwords: make(map[int]string),
+		signedOut: make(map[int]int),
+	}
+	previous := userTokenStorage
+	userTokenStorage = store
+	t.Cleanup(func() { userTokenStorage = previous })
+
+	// Keep password hashing fast
+	cost := passwordCost
+	passwordCost = bcrypt.MinCost
+	t.Cleanup(func() { passwordCost = cost })
+
+	return store
+}
+
+var userTokenTestUser = models.User{ID: 3, Username: "bob", Email: "bob@example.com", Role: models.RoleAuthor}
+
+var userTokenPurposes = []models.TokenPurpose{models.TokenPasswordReset, models.TokenInvite}
+
+func TestUserTokenWorksOnce(t *testing.T) {
+	for _, purpose := range userTokenPurposes {
+		t.Run(string(purpose), func(t *testing.T) {
+			store := useFakeUserTokenStore(t, userTokenTestUser)
+
+			token, err := IssueUserToken(userTokenTestUser.ID, purpose)
+			if err != nil {
+				t.Fatalf("IssueUserToken: %v", err)
+			}
+			if len(store.tokens) != 1 || store.tokens[0].hash == token {
+				t.Fatal("the token itself was stored instead of its hash")
+			}
+
+			// Looking at the link doesn't use the token up
+			for range 2 {
+				user, err := UserFromToken(purpose, token)
+				if err != nil || user.ID != userTokenTestUser.ID {
+					t.Fatalf("UserFromToken = %v, %v", user.ID, err)
+				}
+			}
+
+			user, err := SetPasswordWithToken(purpose, token, "correct horse battery staple")
+			if err != nil {
+				t.Fatalf("SetPasswordWithToken: %v", err)
+			}
+			if user.ID != userTokenTestUser.ID {
+				t.Fatalf("set the password of user %d, want %d", user.ID, userTokenTestUser.ID)
+			}
+			if bcrypt.CompareHashAndPassword([]byte(store.passwords[user.ID]), []byte("correct horse battery staple")) != nil {
+				t.Fatal("stored password hash does not match the new password")
+			}
+			if store.signedOut[user.ID] != 1 {
+				t.Fatal("user was not signed out everywhere")
+			}
+
+			// The token is used up
+			if _, err := UserFromToken(purpose, token); !errors.Is(err, models.ErrInvalidUserToken) {
+				t.Fatalf("UserFromToken after use: err = %v, want ErrInvalidUserToken", err)
+			}
+			if _, err := SetPasswordWithToken(purpose, token, "another password"); !errors.Is(err, models.ErrInvalidUserToken) {
+				t.Fatalf("reusing the token: err = %v, want ErrInvalidUserToken", err)
+			}
+			if bcrypt.CompareHashAndPassword([]byte(store.passwords[user.ID]), []byte("correct horse battery staple")) != nil {
+				t.Fatal("reused token changed the password")
+			}
+		})
+	}
+}
+
+func TestUserTokenExpires(t *testing.T) {
+	for _, purpose := range userTokenPurposes {
+		t.Run(string(purpose), func(t *testing.T) {
+			store := useFakeUserTokenStore(t, userTokenTestUser)
+
+			token, err := IssueUserToken(userTokenTestUser.ID, purpose)
+			if err != nil {
+				t.Fatalf("IssueUserToken: %v", err)
+			}
+
+			// Still valid just before the end of its lifetime
+			store.now = store.now.Add(UserTokenLifetime(purpose) - time.Second)
+			if _, err := UserFromToken(purpose, token); err != nil {
+				t.Fatalf("UserFromToken before expiry: %v", err)
+			}
+
+			store.now = store.now.Add(2 * time.Second)
+			if _, err := UserFromToken(purpose, token); !errors.Is(err, models.ErrInvalidUserToken) {
+				t.Fatalf("UserFromToken after expiry: err = %v, want ErrInvalidUserToken", err)
+			}
+			if _, err := SetPasswordWithToken(purpose, token, "too late"); !errors.Is(err, models.ErrInvalidUserToken) {
+				t.Fatalf("SetPasswordWithToken after expiry: err = %v, want ErrInvalidUserToken", err)
+			}
+			if _, ok := store.passwords[userTokenTestUser.ID]; ok {
+				t.Fatal("expired token set a password")
+			}
+		})
+	}
+}
+
+func TestUserTokenLifetimes(t *testing.T) {
+	if UserTokenLifetime(models.TokenPasswordReset) != passwordResetLifetime {
+		t.Fatal("password reset tokens don't use the password reset lifetime")
+	}
+	if UserTokenLifetime(models.TokenInvite) != inviteLifetime {
+		t.Fatal("invitations don't use the invite lifetime")
+	}
+}
+
+func TestUserTokenRejectsOtherPurposeAndOlderTokens(t *testing.T) {
+	useFakeUserTokenStore(t, userTokenTestUser)
+
+	reset, err := IssueUserToken(userTokenTestUser.ID, models.TokenPasswordReset)
+	if err != nil {
+		t.Fatalf("IssueUserToken: %v", err)
+	}
+
+	// A reset link can't be used to accept an invitation
+	if _, err := SetPasswordWithToken(models.TokenInvite, reset, "password"); !errors.Is(err, models.ErrInvalidUserToken) {
+		t.Fatalf("reset token as invite: err = %v, want ErrInvalidUserToken", err)
+	}
+
+	// Asking for a new link invalidates the previous one
+	newer, err := IssueUserToken(userTokenTestUser.ID, models.TokenPasswordReset)
+	if err != nil {
+		t.Fatalf("IssueUserToken: %v", err)
+	}
+	if _, err := UserFromToken(models.TokenPasswordReset, reset); !errors.Is(err, models.ErrInvalidUserToken) {
+		t.Fatalf("older token: err = %v, want ErrInvalidUserToken", err)
+	}
+	if _, err := UserFromToken(models.TokenPasswordReset, newer); err != nil {
+		t.Fatalf("newer token: %v", err)
+	}
+
+	// Empty tokens never match
+	if _, err := UserFromToken(models.TokenPasswordReset, ""); !errors.Is(err, models.ErrInvalidUserToken) {
+		t.Fatalf("empty token: err = %v, want ErrInvalidUserToken", err)
+	}
+}
+
+func TestSetPasswordWithTokenKeepsTokenOnInvalidPassword(t *testing.T) {
+	useFakeUserTokenStore(t, userTokenTestUser)
+
+	token, err := IssueUserToken(userTokenTestUser.ID, models.TokenInvite)
+	if err != nil {
+		t.Fatalf("IssueUserToken: %v", err)
+	}
+	if _, err := SetPasswordWithToken(models.TokenInvite, token, ""); err == nil {
+		t.Fatal("set an empty password")
+	}
+	if _, err := UserFromToken(models.TokenInvite, token); err != nil {
+		t.Fatalf("token was used up by a rejected password: %v", err)
+	}
+}
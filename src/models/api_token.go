@@ -0,0 +1,148 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chewawi_web/src/database"
+
+	"github.com/lib/pq"
+)
+
+// Scope is an API action a personal access token may be allowed to perform
+type Scope string
+
+const (
+	ScopePostsRead   Scope = "posts:read"
+	ScopePostsWrite  Scope = "posts:write"
+	ScopePostsDelete Scope = "posts:delete"
+)
+
+// Scopes lists every scope a token can be given
+var Scopes = []Scope{ScopePostsRead, ScopePostsWrite, ScopePostsDelete}
+
+// Valid reports whether the scope exists
+func (s Scope) Valid() bool {
+	for _, scope := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken is a personal access token a user created for scripts
+type APIToken struct {
+	ID     int
+	UserID int
+	Name   string
+	// Prefix is the start of the token, shown so users can tell their tokens apart
+	Prefix     string
+	Scopes     []Scope
+	Created    time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	LastUsedIP string
+}
+
+// Has reports whether the token was given the scope
+func (t APIToken) Has(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenColumns are the columns selected for an APIToken, in the order scanAPIToken expects
+const apiTokenColumns = "id, user_id, name, prefix, scopes, created, expires_at, last_used_at, last_used_ip"
+
+// scanAPIToken scans a row selected with apiTokenColumns into an APIToken
+func scanAPIToken(row scanner) (APIToken, error) {
+	var token APIToken
+	var scopes []string
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.Name, &token.Prefix, pq.Array(&scopes),
+		&token.Created, &token.ExpiresAt, &token.LastUsedAt, &token.LastUsedIP,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return token, &NotFoundError{Kind: "token"}
+	}
+
+	for _, scope := range scopes {
+		token.Scopes = append(token.Scopes, Scope(scope))
+	}
+	return token, err
+}
+
+// GetAPITokensByUser retrieves all tokens of a user, newest first
+func GetAPITokensByUser(userID int) ([]APIToken, error) {
+	rows, err := database.DB.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = $1 ORDER BY created DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// GetActiveAPIToken retrieves an unexpired token by the hash of its value
+func GetActiveAPIToken(hash string) (APIToken, error) {
+	row := database.DB.QueryRow(
+		"SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())",
+		hash,
+	)
+	return scanAPIToken(row)
+}
+
+// CreateAPIToken stores a new token by the hash of its value. It expires after
+// the given number of days, or never if that is zero.
+func CreateAPIToken(userID int, name, prefix, hash string, scopes []Scope, expiresInDays int) error {
+	values := make([]string, len(scopes))
+	for i, scope := range scopes {
+		values[i] = string(scope)
+	}
+
+	_, err := database.DB.Exec(
+		`INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $6::int > 0 THEN NOW() + make_interval(days => $6::int) END)`,
+		userID, name, prefix, hash, pq.Array(values), expiresInDays,
+	)
+	return err
+}
+
+// TouchAPIToken records that a token was just used, and from where
+func TouchAPIToken(id int, ip string) error {
+	_, err := database.DB.Exec("UPDATE api_tokens SET last_used_at = NOW(), last_used_ip = $2 WHERE id = $1", id, ip)
+	return err
+}
+
+// DeleteAPIToken revokes one of a user's tokens
+func DeleteAPIToken(id, userID int) error {
+	result, err := database.DB.Exec("DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return &NotFoundError{Kind: "token"}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Errors returned by the models wrap one of these, so callers can tell with
+// errors.Is what went wrong without matching on messages
+var (
+	// ErrNotFound means the record doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the change clashes with another record, such as a taken username
+	ErrConflict = errors.New("conflict")
+	// ErrValidation means a value was rejected before reaching the database
+	ErrValidation = errors.New("invalid value")
+)
+
+// NotFoundError reports that a record of some kind doesn't exist
+type NotFoundError struct {
+	// Kind is what was looked for, such as "post"
+	Kind string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Kind + " not found"
+}
+
+// Is makes errors.Is(err, ErrNotFound) match
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ConflictError reports a change that clashes with another record
+type ConflictError struct {
+	// Field holds the clashing value, when one field does
+	Field   string
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// Is makes errors.Is(err, ErrConflict) match
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// ValidationError reports an invalid value for a field
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// Is makes errors.Is(err, ErrValidation) match
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// uniqueViolation is the Postgres error code for a duplicate key
+const uniqueViolation = "23505"
+
+// conflictOnDuplicate turns a duplicate key error from Postgres into a ConflictError
+// for the field with the message, passing any other error through
+func conflictOnDuplicate(err error, field, message string) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return &ConflictError{Field: field, Message: message}
+	}
+	return err
+}
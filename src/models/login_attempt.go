@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+
+	"chewawi_web/src/database"
+)
+
+// LoginAttempt is a recorded sign-in attempt
+type LoginAttempt struct {
+	ID        int
+	Username  string
+	IP        string
+	Succeeded bool
+	Created   time.Time
+}
+
+// RecordLoginAttempt stores a sign-in attempt and prunes the ones older than 30 days
+func RecordLoginAttempt(username, ip string, succeeded bool) error {
+	_, err := database.DB.Exec(
+		"INSERT INTO login_attempts (username, ip, succeeded) VALUES ($1, $2, $3)",
+		username, ip, succeeded,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.Exec("DELETE FROM login_attempts WHERE created < NOW() - INTERVAL '30 days'")
+	return err
+}
+
+// RecentLoginFailuresByUsername counts the failed attempts for a username within the
+// window and since its last successful sign-in, and returns how long ago the last one was
+func RecentLoginFailuresByUsername(username string, window time.Duration) (int, time.Duration, error) {
+	return recentLoginFailures(`
+		SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MAX(created)), 0) FROM login_attempts
+		WHERE username = $1 AND NOT succeeded AND created > NOW() - make_interval(secs => $2)
+			AND created > (SELECT COALESCE(MAX(created), 'epoch') FROM login_attempts WHERE username = $1 AND succeeded)
+	`, username, window)
+}
+
+// RecentLoginFailuresByIP counts the failed attempts from an IP within the window,
+// and returns how long ago the last one was. Successful sign-ins don't reset it, so
+// signing in to one account doesn't let an address keep guessing at others.
+func RecentLoginFailuresByIP(ip string, window time.Duration) (int, time.Duration, error) {
+	return recentLoginFailures(`
+		SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MAX(created)), 0) FROM login_attempts
+		WHERE ip = $1 AND NOT succeeded AND created > NOW() - make_interval(secs => $2)
+	`, ip, window)
+}
+
+// recentLoginFailures runs one of the failure counting queries
+func recentLoginFailures(query, key string, window time.Duration) (int, time.Duration, error) {
+	var count int
+	var secondsAgo float64
+	err := database.DB.QueryRow(query, key, window.Seconds()).Scan(&count, &secondsAgo)
+	return count, time.Duration(secondsAgo * float64(time.Second)), err
+}
+
+// GetRecentLoginFailures retrieves the latest failed sign-in attempts
+func GetRecentLoginFailures(limit int) ([]LoginAttempt, error) {
+	rows, err := database.DB.Query(
+		"SELECT id, username, ip, succeeded, created FROM login_attempts WHERE NOT succeeded ORDER BY created DESC LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []LoginAttempt
+	for rows.Next() {
+		var attempt LoginAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &attempt.Succeeded, &attempt.Created); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
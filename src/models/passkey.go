@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"chewawi_web/src/database"
+)
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	ID           int
+	UserID       int
+	Name         string
+	CredentialID []byte
+	// Data is the full credential record as JSON, as handed out by the WebAuthn library
+	Data       []byte
+	SignCount  int64
+	Created    time.Time
+	LastUsedAt *time.Time
+}
+
+// passkeyColumns are the columns selected for a Passkey, in the order scanPasskey expects
+const passkeyColumns = "id, user_id, name, credential_id, data, sign_count, created, last_used_at"
+
+// scanPasskey scans a row selected with passkeyColumns into a Passkey
+func scanPasskey(row scanner) (Passkey, error) {
+	var passkey Passkey
+	err := row.Scan(
+		&passkey.ID, &passkey.UserID, &passkey.Name, &passkey.CredentialID, &passkey.Data,
+		&passkey.SignCount, &passkey.Created, &passkey.LastUsedAt,
+	)
+	return passkey, err
+}
+
+// GetPasskeysByUser retrieves all passkeys of a user
+func GetPasskeysByUser(userID int) ([]Passkey, error) {
+	rows, err := database.DB.Query("SELECT "+passkeyColumns+" FROM passkeys WHERE user_id = $1 ORDER BY created", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, passkey)
+	}
+
+	return passkeys, rows.Err()
+}
+
+// CreatePasskey stores a newly registered passkey
+func CreatePasskey(userID int, name string, credentialID, data []byte, signCount int64) error {
+	_, err := database.DB.Exec(
+		"INSERT INTO passkeys (user_id, name, credential_id, data, sign_count) VALUES ($1, $2, $3, $4, $5)",
+		userID, name, credentialID, data, signCount,
+	)
+	return err
+}
+
+// UpdatePasskeyUsage records a successful sign-in with a passkey and its new signature counter
+func UpdatePasskeyUsage(id int, data []byte, signCount int64) error {
+	_, err := database.DB.Exec(
+		"UPDATE passkeys SET data = $1, sign_count = $2, last_used_at = NOW() WHERE id = $3",
+		data, signCount, id,
+	)
+	return err
+}
+
+// DeletePasskey deletes one of a user's passkeys
+func DeletePasskey(id, userID int) error {
+	result, err := database.DB.Exec("DELETE FROM passkeys WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return &NotFoundError{Kind: "passkey"}
+	}
+
+	return nil
+}
@@ -2,24 +2,64 @@ package models
 
 import (
 	"database/sql"
-	"errors"
+	"fmt"
+	"log"
 	"strings"
 	"time"
 
 	"chewawi_web/src/database"
+	"chewawi_web/src/utils"
+
+	"github.com/lib/pq"
 )
 
 type Post struct {
-	ID      int       `json:"id"`
-	Title   string    `json:"title"`
-	Content string    `json:"content"`
-	Slug    string    `json:"slug"`
-	Created time.Time `json:"created"`
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Summary   string    `json:"summary"`
+	Content   string    `json:"content"`
+	Slug      string    `json:"slug"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+	Published bool      `json:"published"`
+	AuthorID  int       `json:"author_id"`
+	Author    User      `json:"author"`
+	Tags      []string  `json:"tags"`
+
+	// Language of the post, and the group it shares with its translations
+	Lang             string `json:"lang"`
+	TranslationGroup int    `json:"translation_group"`
+
+	// HTML rendered from the content when the post was saved, and the version of the
+	// renderer that made it, see ContentHTML
+	RenderedHTML  string `json:"-"`
+	RenderVersion string `json:"-"`
 }
 
-// GetAllPosts retrieves all posts from the database
-func GetAllPosts() ([]Post, error) {
-	rows, err := database.DB.Query("SELECT id, title, content, slug, created FROM posts ORDER BY created DESC")
+// postSelect selects every post column along with the post's author
+const postSelect = `SELECT p.id, p.title, p.summary, p.content, p.slug, p.created, COALESCE(p.updated, p.created), p.published, p.lang, p.translation_group, p.rendered_html, p.render_version, p.tags,
+	u.id, u.username, u.display_name, u.bio, u.avatar_url, u.role, u.created
+	FROM posts p JOIN users u ON u.id = p.author_id`
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanPost scans a row selected with postSelect into a Post
+func scanPost(row scanner) (Post, error) {
+	var post Post
+	err := row.Scan(
+		&post.ID, &post.Title, &post.Summary, &post.Content, &post.Slug, &post.Created, &post.Updated, &post.Published, &post.Lang, &post.TranslationGroup, &post.RenderedHTML, &post.RenderVersion, pq.Array(&post.Tags),
+		&post.Author.ID, &post.Author.Username, &post.Author.DisplayName, &post.Author.Bio, &post.Author.AvatarURL, &post.Author.Role, &post.Author.Created,
+	)
+	post.AuthorID = post.Author.ID
+	return post, err
+}
+
+// queryPosts runs a query selecting posts with postSelect and scans every row
+func queryPosts(query string, args ...any) ([]Post, error) {
+	rows, err := database.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -27,32 +67,112 @@ func GetAllPosts() ([]Post, error) {
 
 	var posts []Post
 	for rows.Next() {
-		var post Post
-		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.Slug, &post.Created); err != nil {
+		post, err := scanPost(rows)
+		if err != nil {
 			return nil, err
 		}
 		posts = append(posts, post)
 	}
 
-	return posts, nil
+	return posts, rows.Err()
+}
+
+// GetAllPosts retrieves all posts from the database, including drafts
+func GetAllPosts() ([]Post, error) {
+	return queryPosts(postSelect + " ORDER BY p.created DESC")
+}
+
+// GetPublishedPosts retrieves all published posts
+func GetPublishedPosts() ([]Post, error) {
+	return queryPosts(postSelect + " WHERE p.published ORDER BY p.created DESC")
+}
+
+// GetPublishedPostsByLang retrieves the published posts written in the given language
+func GetPublishedPostsByLang(lang string) ([]Post, error) {
+	return queryPosts(postSelect+" WHERE p.published AND p.lang = $1 ORDER BY p.created DESC", lang)
+}
+
+// GetPostsByAuthor retrieves all posts written by the given user, including drafts
+func GetPostsByAuthor(authorID int) ([]Post, error) {
+	return queryPosts(postSelect+" WHERE p.author_id = $1 ORDER BY p.created DESC", authorID)
+}
+
+// GetPublishedPostsByAuthor retrieves the published posts written by the given user
+func GetPublishedPostsByAuthor(authorID int) ([]Post, error) {
+	return queryPosts(postSelect+" WHERE p.author_id = $1 AND p.published ORDER BY p.created DESC", authorID)
 }
 
 // GetPostBySlug retrieves a post by its slug
 func GetPostBySlug(slug string) (Post, error) {
-	var post Post
-	err := database.DB.QueryRow("SELECT id, title, content, slug, created FROM posts WHERE slug = $1", slug).
-		Scan(&post.ID, &post.Title, &post.Content, &post.Slug, &post.Created)
+	post, err := scanPost(database.DB.QueryRow(postSelect+" WHERE p.slug = $1", slug))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return Post{}, errors.New("post not found")
+			return Post{}, &NotFoundError{Kind: "post"}
 		}
 		return Post{}, err
 	}
 	return post, nil
 }
 
-// CreatePost creates a new post
-func CreatePost(title, content string) (Post, error) {
+// GetPublishedTranslations retrieves the published translations of a post, ordered
+// by language
+func GetPublishedTranslations(post Post) ([]Post, error) {
+	return queryPosts(postSelect+" WHERE p.published AND p.translation_group = $1 AND p.id <> $2 ORDER BY p.lang", post.TranslationGroup, post.ID)
+}
+
+// GetTranslatablePosts retrieves the posts an author can make a post a translation
+// of: the published ones, their own, and those already in the translation group
+func GetTranslatablePosts(authorID, translationGroup int) ([]Post, error) {
+	return queryPosts(postSelect+" WHERE p.published OR p.author_id = $1 OR p.translation_group = $2 ORDER BY p.created DESC", authorID, translationGroup)
+}
+
+// translationTaken reports whether a post other than the given one is in the
+// translation group with the language, or with any language when lang is empty
+func translationTaken(group int, lang string, exceptID int) (bool, error) {
+	var taken bool
+	err := database.DB.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM posts WHERE translation_group = $1 AND ($2 = '' OR lang = $2) AND id <> $3)",
+		group, lang, exceptID,
+	).Scan(&taken)
+	return taken, err
+}
+
+// GetPreviousPost retrieves the published post in the same language right before
+// the given one. It returns an empty post when there is none.
+func GetPreviousPost(post Post) (Post, error) {
+	previous, err := scanPost(database.DB.QueryRow(
+		postSelect+" WHERE p.published AND (p.created, p.id) < ($1, $2) AND p.lang = $3 ORDER BY p.created DESC, p.id DESC LIMIT 1",
+		post.Created, post.ID, post.Lang,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Post{}, nil
+		}
+		return Post{}, err
+	}
+	return previous, nil
+}
+
+// GetNextPost retrieves the published post in the same language right after the
+// given one. It returns an empty post when there is none.
+func GetNextPost(post Post) (Post, error) {
+	next, err := scanPost(database.DB.QueryRow(
+		postSelect+" WHERE p.published AND (p.created, p.id) > ($1, $2) AND p.lang = $3 ORDER BY p.created ASC, p.id ASC LIMIT 1",
+		post.Created, post.ID, post.Lang,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Post{}, nil
+		}
+		return Post{}, err
+	}
+	return next, nil
+}
+
+// CreatePost creates a new post written by the given author in the language. A
+// translationGroup of 0 starts a group of its own, any other makes the post a
+// translation of the posts in that group.
+func CreatePost(title, summary, content, lang string, tags []string, translationGroup, authorID int, published bool) (Post, error) {
 	// Generate slug from title
 	slug := generateSlug(title)
 	
@@ -68,45 +188,162 @@ func CreatePost(title, content string) (Post, error) {
 		slug = slug + "-" + time.Now().Format("20060102150405")
 	}
 	
-	// Insert post
-	var post Post
-	err = database.DB.QueryRow(
-		"INSERT INTO posts (title, content, slug) VALUES ($1, $2, $3) RETURNING id, title, content, slug, created",
-		title, content, slug,
-	).Scan(&post.ID, &post.Title, &post.Content, &post.Slug, &post.Created)
+	// A group has one post per language
+	if translationGroup != 0 {
+		taken, err := translationTaken(translationGroup, lang, 0)
+		if err != nil {
+			return Post{}, err
+		}
+		if taken {
+			return Post{}, &ConflictError{Field: "lang", Message: "that post already has a translation in this language"}
+		}
+	}
+	
+	// Insert post, rendered once here rather than on every view
+	_, err = database.DB.Exec(
+		`INSERT INTO posts (title, summary, content, slug, author_id, published, lang, translation_group, rendered_html, render_version, tags)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE(NULLIF($8, 0), nextval('post_translation_groups')), $9, $10, $11)`,
+		title, summary, content, slug, authorID, published, lang, translationGroup, utils.MarkdownToHTML(content), utils.MarkdownVersion, pq.Array(NormalizeTags(tags)),
+	)
+	
+	if err != nil {
+		return Post{}, err
+	}
 	
+	post, err := GetPostBySlug(slug)
 	if err != nil {
 		return Post{}, err
 	}
 	
+	// Recompute related posts in the background now that the corpus changed
+	refreshRelatedPostsAfterSave()
+	
 	return post, nil
 }
 
-// UpdatePost updates an existing post
-func UpdatePost(slug string, title, content string) (Post, error) {
+// UpdatePost updates an existing post. A translationGroup of 0 takes the post out of
+// its group, any other moves it to that group.
+func UpdatePost(slug string, title, summary, content, lang string, tags []string, translationGroup int, published bool) (Post, error) {
 	// Check if post exists
-	_, err := GetPostBySlug(slug)
+	current, err := GetPostBySlug(slug)
+	if err != nil {
+		return Post{}, err
+	}
+	
+	// Give a post leaving its translations a group of its own
+	if translationGroup == 0 {
+		translationGroup = current.TranslationGroup
+		shared, err := translationTaken(current.TranslationGroup, "", current.ID)
+		if err != nil {
+			return Post{}, err
+		}
+		if shared {
+			err = database.DB.QueryRow("SELECT nextval('post_translation_groups')").Scan(&translationGroup)
+			if err != nil {
+				return Post{}, err
+			}
+		}
+	}
+	
+	// A group has one post per language
+	taken, err := translationTaken(translationGroup, lang, current.ID)
 	if err != nil {
 		return Post{}, err
 	}
+	if taken {
+		return Post{}, &ConflictError{Field: "lang", Message: "that post already has a translation in this language"}
+	}
 	
 	// Generate new slug if title changed
 	newSlug := generateSlug(title)
 	
-	// Update post
-	var post Post
-	err = database.DB.QueryRow(
-		"UPDATE posts SET title = $1, content = $2, slug = $3 WHERE slug = $4 RETURNING id, title, content, slug, created",
-		title, content, newSlug, slug,
-	).Scan(&post.ID, &post.Title, &post.Content, &post.Slug, &post.Created)
+	// Update post, rendered once here rather than on every view
+	_, err = database.DB.Exec(
+		`UPDATE posts SET title = $1, summary = $2, content = $3, slug = $4, published = $5, lang = $6, translation_group = $7,
+		rendered_html = $8, render_version = $9, tags = $10, updated = NOW()
+		WHERE slug = $11`,
+		title, summary, content, newSlug, published, lang, translationGroup, utils.MarkdownToHTML(content), utils.MarkdownVersion, pq.Array(NormalizeTags(tags)), slug,
+	)
+	err = conflictOnDuplicate(err, "title", "another post already has this title")
 	
 	if err != nil {
 		return Post{}, err
 	}
 	
+	post, err := GetPostBySlug(newSlug)
+	if err != nil {
+		return Post{}, err
+	}
+	
+	// Recompute related posts in the background now that the corpus changed
+	refreshRelatedPostsAfterSave()
+	
 	return post, nil
 }
 
+// NormalizeTags lowercases and trims tags, dropping empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// TagList returns the post's tags separated by commas, as typed in the post form
+func (p Post) TagList() string {
+	return strings.Join(p.Tags, ", ")
+}
+
+// ContentHTML returns the post's content as HTML. It serves the HTML stored when the
+// post was saved, unless another version of the renderer made it, in which case the
+// post is rendered and stored again.
+func (p *Post) ContentHTML() string {
+	if p.RenderVersion != utils.MarkdownVersion {
+		if err := RenderPost(p); err != nil {
+			// The fresh HTML is still served, it just gets rendered again next time
+			log.Printf("Error storing rendered post %d: %v", p.ID, err)
+		}
+	}
+	return p.RenderedHTML
+}
+
+// RenderPost renders a post's content with the current renderer and stores the HTML.
+// Nothing is stored if the content was edited since the post was read.
+func RenderPost(post *Post) error {
+	post.RenderedHTML = utils.MarkdownToHTML(post.Content)
+	post.RenderVersion = utils.MarkdownVersion
+	
+	_, err := database.DB.Exec(
+		"UPDATE posts SET rendered_html = $1, render_version = $2 WHERE id = $3 AND content = $4",
+		post.RenderedHTML, post.RenderVersion, post.ID, post.Content,
+	)
+	return err
+}
+
+// RerenderPosts renders every post again with the current renderer, returning how
+// many it rendered
+func RerenderPosts() (int, error) {
+	posts, err := GetAllPosts()
+	if err != nil {
+		return 0, err
+	}
+	
+	for i := range posts {
+		if err := RenderPost(&posts[i]); err != nil {
+			return i, fmt.Errorf("rendering post %q: %w", posts[i].Slug, err)
+		}
+	}
+	
+	return len(posts), nil
+}
+
 // DeletePost deletes a post by its slug
 func DeletePost(slug string) error {
 	result, err := database.DB.Exec("DELETE FROM posts WHERE slug = $1", slug)
@@ -120,9 +357,12 @@ func DeletePost(slug string) error {
 	}
 	
 	if rowsAffected == 0 {
-		return errors.New("post not found")
+		return &NotFoundError{Kind: "post"}
 	}
 	
+	// Recompute related posts in the background now that the corpus changed
+	refreshRelatedPostsAfterSave()
+	
 	return nil
 }
 
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"chewawi_web/src/utils"
+
+	"github.com/lib/pq"
+)
+
+// maxRelatedPosts is how many related posts are cached for each post
+const maxRelatedPosts = 10
+
+// relatedCache holds the IDs of the precomputed related posts, keyed by post ID.
+// The posts themselves are loaded when shown, so author names and avatars are
+// always current.
+var relatedCache struct {
+	sync.RWMutex
+	built bool
+	ids   map[int][]int
+}
+
+// relatedBuild lets only one rebuild of the related posts run at a time
+var relatedBuild sync.Mutex
+
+// relatedRefresh asks the background worker for a rebuild. It holds one request, so
+// the saves made while a rebuild runs are all handled by the next one.
+var relatedRefresh = make(chan struct{}, 1)
+
+// startRelatedWorker starts the background worker on the first save
+var startRelatedWorker sync.Once
+
+// GetRelatedPosts returns up to limit posts that are most similar to the given post
+func GetRelatedPosts(postID int, limit int) ([]Post, error) {
+	ids, built := cachedRelatedIDs(postID)
+
+	// Build the cache on first use
+	if !built {
+		if err := buildRelatedPosts(); err != nil {
+			return nil, err
+		}
+		ids, _ = cachedRelatedIDs(postID)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// Posts unpublished or deleted since the last rebuild are left out
+	related, err := getPublishedPostsByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related, nil
+}
+
+// cachedRelatedIDs returns the cached related post IDs of a post, and whether the
+// cache is built at all
+func cachedRelatedIDs(postID int) ([]int, bool) {
+	relatedCache.RLock()
+	defer relatedCache.RUnlock()
+	return relatedCache.ids[postID], relatedCache.built
+}
+
+// buildRelatedPosts builds the cache unless another request did while this one
+// waited, so concurrent first requests share a single build
+func buildRelatedPosts() error {
+	relatedBuild.Lock()
+	defer relatedBuild.Unlock()
+
+	relatedCache.RLock()
+	built := relatedCache.built
+	relatedCache.RUnlock()
+	if built {
+		return nil
+	}
+	return computeRelatedPosts()
+}
+
+// RefreshRelatedPosts recomputes the related posts of every published post, among
+// the posts in its language. Posts sharing the most tags come first. Ties, and
+// posts sharing no tags, are ranked by the cosine of the TF-IDF vectors of each
+// post's title and body, so it has to be recomputed for the whole corpus whenever
+// a post is saved.
+func RefreshRelatedPosts() error {
+	relatedBuild.Lock()
+	defer relatedBuild.Unlock()
+	return computeRelatedPosts()
+}
+
+// computeRelatedPosts does the work of RefreshRelatedPosts, with relatedBuild held
+func computeRelatedPosts() error {
+	posts, err := GetPublishedPosts()
+	if err != nil {
+		return err
+	}
+
+	// Count the title twice so it weighs more than a passing mention in the body
+	documents := make([]string, len(posts))
+	for i, post := range posts {
+		documents[i] = post.Title + " " + post.Title + " " + post.Content
+	}
+	vectors := utils.TFIDFVectors(documents)
+
+	type scored struct {
+		id         int
+		sharedTags int
+		score      float64
+	}
+
+	related := make(map[int][]int, len(posts))
+	for i, post := range posts {
+		var candidates []scored
+		for j, other := range posts {
+			// Readers are only pointed to posts they can read
+			if i == j || other.Lang != post.Lang {
+				continue
+			}
+			sharedTags := countSharedTags(post.Tags, other.Tags)
+			score := utils.CosineSimilarity(vectors[i], vectors[j])
+			if sharedTags > 0 || score > 0 {
+				candidates = append(candidates, scored{id: other.ID, sharedTags: sharedTags, score: score})
+			}
+		}
+
+		sort.SliceStable(candidates, func(a, b int) bool {
+			if candidates[a].sharedTags != candidates[b].sharedTags {
+				return candidates[a].sharedTags > candidates[b].sharedTags
+			}
+			return candidates[a].score > candidates[b].score
+		})
+		if len(candidates) > maxRelatedPosts {
+			candidates = candidates[:maxRelatedPosts]
+		}
+
+		for _, candidate := range candidates {
+			related[post.ID] = append(related[post.ID], candidate.id)
+		}
+	}
+
+	relatedCache.Lock()
+	relatedCache.ids = related
+	relatedCache.built = true
+	relatedCache.Unlock()
+
+	return nil
+}
+
+// getPublishedPostsByIDs retrieves the published posts with the IDs, in the order
+// of the IDs
+func getPublishedPostsByIDs(ids []int) ([]Post, error) {
+	ids64 := make([]int64, len(ids))
+	for i, id := range ids {
+		ids64[i] = int64(id)
+	}
+	posts, err := queryPosts(postSelect+" WHERE p.published AND p.id = ANY($1)", pq.Array(ids64))
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]Post, len(posts))
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+	ordered := make([]Post, 0, len(posts))
+	for _, id := range ids {
+		if post, ok := byID[id]; ok {
+			ordered = append(ordered, post)
+		}
+	}
+	return ordered, nil
+}
+
+// countSharedTags counts the tags two posts have in common
+func countSharedTags(tags, others []string) int {
+	shared := 0
+	for _, tag := range tags {
+		for _, other := range others {
+			if tag == other {
+				shared++
+				break
+			}
+		}
+	}
+	return shared
+}
+
+// refreshRelatedPostsAfterSave has the related posts recomputed in the background
+// after a post changes, so saving doesn't wait for it
+func refreshRelatedPostsAfterSave() {
+	startRelatedWorker.Do(func() {
+		go refreshRelatedPostsWorker()
+	})
+
+	// A rebuild is already waiting to run when the channel is full
+	select {
+	case relatedRefresh <- struct{}{}:
+	default:
+	}
+}
+
+// refreshRelatedPostsWorker rebuilds the related posts whenever asked to, logging
+// rather than stopping if it goes wrong
+func refreshRelatedPostsWorker() {
+	for range relatedRefresh {
+		if err := RefreshRelatedPosts(); err != nil {
+			log.Printf("Error refreshing related posts: %v", err)
+		}
+	}
+}
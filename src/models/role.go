@@ -0,0 +1,54 @@
+package models
+
+// Role is the access level of a user
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleAuthor Role = "author"
+	RoleViewer Role = "viewer"
+)
+
+// Roles lists every role, from most to least privileged
+var Roles = []Role{RoleAdmin, RoleEditor, RoleAuthor, RoleViewer}
+
+// Permission is an action a role may be allowed to perform
+type Permission string
+
+const (
+	PermViewDashboard  Permission = "dashboard:view"
+	PermWritePosts     Permission = "posts:write"
+	PermEditAnyPost    Permission = "posts:edit_any"
+	PermPublishPosts   Permission = "posts:publish"
+	PermDeleteAnyPost  Permission = "posts:delete_any"
+	PermManageUsers    Permission = "users:manage"
+	PermManageSettings Permission = "settings:manage"
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermViewDashboard, PermWritePosts, PermEditAnyPost, PermPublishPosts, PermDeleteAnyPost,
+		PermManageUsers, PermManageSettings,
+	},
+	RoleEditor: {PermViewDashboard, PermWritePosts, PermEditAnyPost, PermPublishPosts, PermDeleteAnyPost},
+	RoleAuthor: {PermViewDashboard, PermWritePosts},
+	RoleViewer: {PermViewDashboard},
+}
+
+// Can reports whether the role grants the given permission
+func (r Role) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid reports whether the role is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"chewawi_web/src/database"
+)
+
+// Session is a signed-in browser, referenced by the ID in its session token
+type Session struct {
+	ID         string
+	UserID     int
+	UserAgent  string
+	IP         string
+	Created    time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// Remember is set for sessions signed in with "remember me"
+	Remember bool
+}
+
+// sessionColumns are the columns selected for a Session, in the order scanSession expects
+const sessionColumns = "id, user_id, user_agent, ip, created, last_seen_at, expires_at, remember"
+
+// scanSession scans a row selected with sessionColumns into a Session
+func scanSession(row scanner) (Session, error) {
+	var session Session
+	err := row.Scan(
+		&session.ID, &session.UserID, &session.UserAgent, &session.IP,
+		&session.Created, &session.LastSeenAt, &session.ExpiresAt, &session.Remember,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, &NotFoundError{Kind: "session"}
+	}
+	return session, err
+}
+
+// Device describes the browser and operating system of the session from its user agent
+func (s Session) Device() string {
+	ua := s.UserAgent
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	system := ""
+	switch {
+	case strings.Contains(ua, "Android"):
+		system = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		system = "iOS"
+	case strings.Contains(ua, "Windows"):
+		system = "Windows"
+	case strings.Contains(ua, "Mac OS X"):
+		system = "macOS"
+	case strings.Contains(ua, "Linux"):
+		system = "Linux"
+	}
+
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
+
+// CreateSession stores a new session with the hash of its refresh token and prunes the expired ones
+func CreateSession(id string, userID int, userAgent, ip, refreshHash string, remember bool, lifetime time.Duration) error {
+	_, err := database.DB.Exec(
+		`INSERT INTO sessions (id, user_id, user_agent, ip, refresh_hash, remember, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW() + make_interval(secs => $7))`,
+		id, userID, userAgent, ip, refreshHash, remember, lifetime.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.Exec("DELETE FROM sessions WHERE expires_at < NOW()")
+	return err
+}
+
+// GetActiveSession retrieves a session that hasn't expired or been revoked
+func GetActiveSession(id string) (Session, error) {
+	row := database.DB.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = $1 AND expires_at > NOW()", id)
+	return scanSession(row)
+}
+
+// GetSessionsByUser retrieves the active sessions of a user, most recently seen first
+func GetSessionsByUser(userID int) ([]Session, error) {
+	rows, err := database.DB.Query(
+		"SELECT "+sessionColumns+" FROM sessions WHERE user_id = $1 AND expires_at > NOW() ORDER BY last_seen_at DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session was just used, at most once a minute
+func TouchSession(id, ip string) error {
+	_, err := database.DB.Exec(
+		"UPDATE sessions SET last_seen_at = NOW(), ip = $2 WHERE id = $1 AND last_seen_at < NOW() - INTERVAL '1 minute'",
+		id, ip,
+	)
+	return err
+}
+
+// RotateRefreshToken replaces the refresh token of an active session, provided the
+// given one is its current token, and reports whether it did
+func RotateRefreshToken(id, oldHash, newHash string) (bool, error) {
+	result, err := database.DB.Exec(
+		`UPDATE sessions SET previous_refresh_hash = refresh_hash, refresh_hash = $3, refreshed_at = NOW()
+		WHERE id = $1 AND refresh_hash = $2 AND expires_at > NOW()`,
+		id, oldHash, newHash,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// CheckPreviousRefreshToken reports whether the hash is the refresh token a session had
+// before its last rotation, and whether that rotation happened within the grace period
+func CheckPreviousRefreshToken(id, hash string, grace time.Duration) (bool, bool, error) {
+	var recent bool
+	err := database.DB.QueryRow(
+		"SELECT refreshed_at > NOW() - make_interval(secs => $3) FROM sessions WHERE id = $1 AND previous_refresh_hash = $2",
+		id, hash, grace.Seconds(),
+	).Scan(&recent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, recent, nil
+}
+
+// DeleteSessionByRefreshToken ends a session given its current or previous refresh token
+func DeleteSessionByRefreshToken(id, hash string) error {
+	_, err := database.DB.Exec(
+		"DELETE FROM sessions WHERE id = $1 AND (refresh_hash = $2 OR previous_refresh_hash = $2)",
+		id, hash,
+	)
+	return err
+}
+
+// DeleteSession ends a session
+func DeleteSession(id string) error {
+	_, err := database.DB.Exec("DELETE FROM sessions WHERE id = $1", id)
+	return err
+}
+
+// DeleteUserSession ends one of a user's sessions
+func DeleteUserSession(id string, userID int) error {
+	result, err := database.DB.Exec("DELETE FROM sessions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return &NotFoundError{Kind: "session"}
+	}
+
+	return nil
+}
+
+// DeleteUserSessions ends all sessions of a user except the one to keep, which may be empty
+func DeleteUserSessions(userID int, keepID string) error {
+	_, err := database.DB.Exec("DELETE FROM sessions WHERE user_id = $1 AND id <> $2", userID, keepID)
+	return err
+}
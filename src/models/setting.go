@@ -0,0 +1,41 @@
+package models
+
+import (
+	"database/sql"
+
+	"chewawi_web/src/database"
+)
+
+// Setting keys
+const (
+	// SettingRequireAdmin2FA makes every admin enroll in two-factor authentication
+	SettingRequireAdmin2FA = "require_admin_2fa"
+)
+
+// GetSetting retrieves a setting, returning defaultValue if it was never set
+func GetSetting(key, defaultValue string) (string, error) {
+	var value string
+	err := database.DB.QueryRow("SELECT value FROM settings WHERE key = $1", key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return defaultValue, nil
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+// GetBoolSetting retrieves a boolean setting, which is false unless set to "true"
+func GetBoolSetting(key string) (bool, error) {
+	value, err := GetSetting(key, "false")
+	return value == "true", err
+}
+
+// SetSetting stores a setting
+func SetSetting(key, value string) error {
+	_, err := database.DB.Exec(
+		"INSERT INTO settings (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value",
+		key, value,
+	)
+	return err
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"errors"
+
+	"chewawi_web/src/database"
+)
+
+// GetTOTPSecret retrieves a user's TOTP secret, which is pending until TOTP is enabled
+func GetTOTPSecret(userID int) (string, error) {
+	var secret string
+	err := database.DB.QueryRow("SELECT totp_secret FROM users WHERE id = $1", userID).Scan(&secret)
+	return secret, err
+}
+
+// SetPendingTOTPSecret stores a new TOTP secret that still has to be confirmed
+func SetPendingTOTPSecret(userID int, secret string) error {
+	_, err := database.DB.Exec(
+		"UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $2",
+		secret, userID,
+	)
+	return err
+}
+
+// EnableTOTP turns on TOTP for a user and replaces their recovery codes with the given hashes
+func EnableTOTP(userID int, recoveryCodeHashes []string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_enabled = TRUE WHERE id = $1 AND totp_secret <> ''", userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("no pending TOTP secret")
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns off TOTP for a user and removes their recovery codes
+func DisableTOTP(userID int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET totp_secret = '', totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code.
+// It returns false if that step or a later one was already used, so a code can't be replayed.
+func UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := database.DB.Exec(
+		"UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1",
+		step, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks a user's recovery code as used and reports whether it was valid
+func UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := database.DB.Exec(
+		"UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := database.DB.QueryRow(
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chewawi_web/src/database"
+
+	"github.com/lib/pq"
+)
+
+type User struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	Email       string    `json:"-"`
+	Role        Role      `json:"role"`
+	TOTPEnabled bool      `json:"-"`
+	Created     time.Time `json:"created"`
+}
+
+// userColumns are the columns selected for a User, in the order scanUser expects
+const userColumns = "id, username, display_name, bio, avatar_url, email, role, totp_enabled, created"
+
+// scanUser scans a row selected with userColumns into a User
+func scanUser(row scanner, extra ...any) (User, error) {
+	var user User
+	dest := append([]any{&user.ID, &user.Username, &user.DisplayName, &user.Bio, &user.AvatarURL, &user.Email, &user.Role, &user.TOTPEnabled, &user.Created}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, &NotFoundError{Kind: "user"}
+		}
+		return User{}, err
+	}
+	return user, nil
+}
+
+// Name returns the name to show for the user, falling back to the username
+func (u User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Username
+}
+
+// Can reports whether the user's role grants the given permission
+func (u User) Can(permission Permission) bool {
+	return u.Role.Can(permission)
+}
+
+// CanEditPost reports whether the user may edit the given post
+func (u User) CanEditPost(post Post) bool {
+	if u.Can(PermEditAnyPost) {
+		return true
+	}
+	return u.Can(PermWritePosts) && post.AuthorID == u.ID
+}
+
+// CanDeletePost reports whether the user may delete the given post
+func (u User) CanDeletePost(post Post) bool {
+	if u.Can(PermDeleteAnyPost) {
+		return true
+	}
+	return u.Can(PermWritePosts) && post.AuthorID == u.ID
+}
+
+// GetAllUsers retrieves all users ordered by username
+func GetAllUsers() ([]User, error) {
+	rows, err := database.DB.Query("SELECT " + userColumns + " FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetUserByID retrieves a user by their ID
+func GetUserByID(id int) (User, error) {
+	return scanUser(database.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id))
+}
+
+// GetUserByUsername retrieves a user by their username
+func GetUserByUsername(username string) (User, error) {
+	return scanUser(database.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE username = $1", username))
+}
+
+// GetUserByEmail retrieves a user by their email address, ignoring case
+func GetUserByEmail(email string) (User, error) {
+	if email == "" {
+		return User{}, &NotFoundError{Kind: "user"}
+	}
+	return scanUser(database.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE LOWER(email) = LOWER($1)", email))
+}
+
+// SetUserEmail changes the email address of a user; an empty address removes it
+func SetUserEmail(id int, email string) (User, error) {
+	user, err := scanUser(database.DB.QueryRow(
+		"UPDATE users SET email = $1 WHERE id = $2 RETURNING "+userColumns,
+		email, id,
+	))
+	return user, conflictOnDuplicate(err, "email", "email address is already used by another user")
+}
+
+// UpdateUserProfile updates the public profile of a user
+func UpdateUserProfile(id int, displayName, bio, avatarURL string) (User, error) {
+	return scanUser(database.DB.QueryRow(
+		"UPDATE users SET display_name = $1, bio = $2, avatar_url = $3 WHERE id = $4 RETURNING "+userColumns,
+		displayName, bio, avatarURL, id,
+	))
+}
+
+// CreateUser creates a new user with the given username and role
+func CreateUser(username string, role Role) (User, error) {
+	if !role.Valid() {
+		return User{}, &ValidationError{Field: "role", Message: "invalid role"}
+	}
+
+	user, err := scanUser(database.DB.QueryRow(
+		"INSERT INTO users (username, display_name, role) VALUES ($1, $1, $2) RETURNING "+userColumns,
+		username, role,
+	))
+	return user, conflictOnDuplicate(err, "username", "username is already taken")
+}
+
+// CreateUserAccount creates a user with an email address and password hash in a
+// single insert, so a clash on either leaves no half-made user behind. The email
+// address or the hash may be empty, for users invited to choose a password.
+func CreateUserAccount(username, email, passwordHash string, role Role) (User, error) {
+	if !role.Valid() {
+		return User{}, &ValidationError{Field: "role", Message: "invalid role"}
+	}
+
+	user, err := scanUser(database.DB.QueryRow(
+		"INSERT INTO users (username, display_name, email, password_hash, role) VALUES ($1, $1, $2, $3, $4) RETURNING "+userColumns,
+		username, email, passwordHash, role,
+	))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Constraint == "users_email_idx" {
+		return user, conflictOnDuplicate(err, "email", "email address is already used by another user")
+	}
+	return user, conflictOnDuplicate(err, "username", "username is already taken")
+}
+
+// DeleteUser deletes a user along with their sessions, passkeys and tokens. It's
+// meant for accounts that never got going; users with posts can't be deleted.
+func DeleteUser(id int) error {
+	result, err := database.DB.Exec("DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return &NotFoundError{Kind: "user"}
+	}
+
+	return nil
+}
+
+// UpdateUserRole changes the role of a user
+func UpdateUserRole(id int, role Role) (User, error) {
+	if !role.Valid() {
+		return User{}, &ValidationError{Field: "role", Message: "invalid role"}
+	}
+
+	return scanUser(database.DB.QueryRow(
+		"UPDATE users SET role = $1 WHERE id = $2 RETURNING "+userColumns,
+		role, id,
+	))
+}
+
+// GetUserCredentials retrieves a user and their password hash by username
+func GetUserCredentials(username string) (User, string, error) {
+	var hash string
+	user, err := scanUser(
+		database.DB.QueryRow("SELECT "+userColumns+", password_hash FROM users WHERE username = $1", username),
+		&hash,
+	)
+	if err != nil {
+		return User{}, "", err
+	}
+	return user, hash, nil
+}
+
+// SetUserPassword stores a new password hash for a user
+func SetUserPassword(id int, hash string) error {
+	result, err := database.DB.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", hash, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return &NotFoundError{Kind: "user"}
+	}
+
+	return nil
+}
+
+// GetUserByOIDCSubject retrieves the user linked to an OpenID Connect identity
+func GetUserByOIDCSubject(issuer, subject string) (User, error) {
+	return scanUser(database.DB.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE oidc_issuer = $1 AND oidc_subject = $2",
+		issuer, subject,
+	))
+}
+
+// LinkOIDCSubject links a user who isn't linked yet to an OpenID Connect identity
+func LinkOIDCSubject(id int, issuer, subject string) error {
+	result, err := database.DB.Exec(
+		"UPDATE users SET oidc_issuer = $1, oidc_subject = $2 WHERE id = $3 AND oidc_subject IS NULL",
+		issuer, subject, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return &ConflictError{Message: "user is already linked to another identity"}
+	}
+
+	return nil
+}
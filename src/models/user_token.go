@@ -0,0 +1,102 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"chewawi_web/src/database"
+)
+
+// TokenPurpose is what a single-use user token lets its holder do
+type TokenPurpose string
+
+const (
+	// TokenPasswordReset lets a user who forgot their password choose a new one
+	TokenPasswordReset TokenPurpose = "password_reset"
+	// TokenInvite lets an invited user choose their first password
+	TokenInvite TokenPurpose = "invite"
+)
+
+// ErrInvalidUserToken is returned for tokens that don't exist, expired or were already used
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// CreateUserToken stores the hash of a new single-use token for the user, valid for
+// the given lifetime. Earlier unused tokens with the same purpose stop working.
+func CreateUserToken(userID int, purpose TokenPurpose, hash string, lifetime time.Duration) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL", userID, purpose)
+	if err != nil {
+		return err
+	}
+
+	// Prune the tokens that expired a while ago
+	_, err = tx.Exec("DELETE FROM user_tokens WHERE expires_at < NOW() - INTERVAL '30 days'")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))",
+		userID, purpose, hash, lifetime.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUserByToken retrieves the user of a token that can still be used, without using it up
+func GetUserByToken(purpose TokenPurpose, hash string) (User, error) {
+	user, err := scanUser(database.DB.QueryRow(
+		`SELECT `+userColumns+` FROM users WHERE id = (
+			SELECT user_id FROM user_tokens
+			WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		)`,
+		hash, purpose,
+	))
+	// No row means the token doesn't exist, expired or was used; anything else is a real failure
+	if errors.Is(err, ErrNotFound) {
+		return User{}, ErrInvalidUserToken
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// UseUserToken marks a token as used and returns the ID of its user.
+// Only one caller can ever use a token.
+func UseUserToken(purpose TokenPurpose, hash string) (int, error) {
+	var userID int
+	err := database.DB.QueryRow(
+		`UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`,
+		hash, purpose,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidUserToken
+	}
+	return userID, err
+}
+
+// HasRecentUserToken reports whether a token with the purpose was created for the
+// user within the window, to keep from sending the same email over and over
+func HasRecentUserToken(userID int, purpose TokenPurpose, window time.Duration) (bool, error) {
+	var exists bool
+	err := database.DB.QueryRow(
+		`SELECT EXISTS (
+			SELECT 1 FROM user_tokens
+			WHERE user_id = $1 AND purpose = $2 AND created > NOW() - make_interval(secs => $3)
+		)`,
+		userID, purpose, window.Seconds(),
+	).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,351 @@
+// Package oidc implements the relying party side of OpenID Connect: discovery,
+// the authorization code flow with PKCE and ID token validation against the
+// provider's JWKS. It only depends on the provider's HTTP endpoints, so it can
+// be pointed at a local mock provider.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config configures a Provider
+type Config struct {
+	// IssuerURL is the provider's issuer, where /.well-known/openid-configuration is served
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested on top of "openid"
+	Scopes []string
+	// HTTPClient talks to the provider, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// Provider is a discovered OpenID Connect provider
+type Provider struct {
+	config   Config
+	metadata metadata
+
+	// keys caches the provider's signing keys by key ID
+	mu     sync.Mutex
+	keys   map[string]any
+	keysAt time.Time
+}
+
+// metadata is the part of the discovery document we use
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Claims are the verified claims of an ID token
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	// Raw holds every claim, for mapping custom claims such as groups
+	Raw map[string]any
+}
+
+// idTokenClaims are the ID token claims checked on top of the registered ones
+type idTokenClaims struct {
+	Nonce string `json:"nonce"`
+	AZP   string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// keysRefreshInterval is how often an unknown key ID may trigger a JWKS refetch
+const keysRefreshInterval = time.Minute
+
+// clockSkew is the leeway given to the provider's clock
+const clockSkew = time.Minute
+
+// NewProvider fetches the provider's discovery document
+func NewProvider(ctx context.Context, config Config) (*Provider, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	p := &Provider{config: config}
+	issuer := strings.TrimSuffix(config.IssuerURL, "/")
+	if err := p.getJSON(ctx, issuer+"/.well-known/openid-configuration", &p.metadata); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	// The discovery document must be about the issuer we asked for
+	if strings.TrimSuffix(p.metadata.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", p.metadata.Issuer, config.IssuerURL)
+	}
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: document is missing endpoints")
+	}
+
+	return p, nil
+}
+
+// AuthCodeURL returns the URL to send the browser to, with the state and nonce
+// to check on the way back and the PKCE challenge of the code verifier
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	values := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + separator + values.Encode()
+}
+
+// Exchange trades an authorization code for tokens and returns the verified
+// claims of the ID token, which must carry the nonce
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var token struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &token); err != nil {
+		return Claims{}, fmt.Errorf("oidc token exchange: %w", err)
+	}
+	if token.Error != "" {
+		return Claims{}, fmt.Errorf("oidc token exchange: %s: %s", token.Error, token.Description)
+	}
+	if token.IDToken == "" {
+		return Claims{}, errors.New("oidc token exchange: no id_token in response")
+	}
+
+	return p.VerifyIDToken(ctx, token.IDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's keys, its
+// issuer, audience, lifetime and nonce, and returns its claims
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	var claims idTokenClaims
+	token, err := jwt.ParseWithClaims(raw, &claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods(asymmetricOnly(p.metadata.SigningAlgs)),
+		jwt.WithIssuer(p.metadata.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil || !token.Valid {
+		return Claims{}, fmt.Errorf("oidc: invalid id token: %w", err)
+	}
+
+	// With several audiences the token must have been issued to us
+	if len(claims.Audience) > 1 && claims.AZP != p.config.ClientID {
+		return Claims{}, errors.New("oidc: id token was issued to another party")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return Claims{}, errors.New("oidc: id token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return Claims{}, errors.New("oidc: id token has no subject")
+	}
+
+	// Decode every claim again for the optional ones
+	mapClaims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, mapClaims); err != nil {
+		return Claims{}, err
+	}
+
+	result := Claims{Subject: claims.Subject, Raw: mapClaims}
+	result.Email, _ = mapClaims["email"].(string)
+	result.EmailVerified, _ = mapClaims["email_verified"].(bool)
+	result.Name, _ = mapClaims["name"].(string)
+	result.PreferredUsername, _ = mapClaims["preferred_username"].(string)
+	return result, nil
+}
+
+// Strings returns a claim holding a string or a list of strings, such as groups
+func (c Claims) Strings(name string) []string {
+	switch value := c.Raw[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// String returns a claim holding a string
+func (c Claims) String(name string) string {
+	value, _ := c.Raw[name].(string)
+	return value
+}
+
+// key returns the provider's public key with the key ID, refetching the JWKS
+// when it's unknown so rotated keys are picked up
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	stale := time.Since(p.keysAt) > keysRefreshInterval
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	p.keysAt = time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a cached key; a token without a key ID matches the only key there is
+func (p *Provider) lookupKey(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// fetchKeys downloads the provider's signing keys
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]any, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]any)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip key types we don't support rather than failing on all keys
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("oidc jwks: no usable signing keys")
+	}
+	return keys, nil
+}
+
+// getJSON fetches a JSON document
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return p.doJSON(req, v)
+}
+
+// doJSON sends a request and decodes its JSON response. Error responses of the
+// token endpoint are JSON too, so 400s are decoded for the caller to inspect.
+func (p *Provider) doJSON(req *http.Request, v any) error {
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// asymmetricOnly drops the algorithms that don't use the provider's public keys,
+// falling back to RS256 which every provider has to support
+func asymmetricOnly(algs []string) []string {
+	var result []string
+	for _, alg := range algs {
+		if strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS") || strings.HasPrefix(alg, "ES") || alg == "EdDSA" {
+			result = append(result, alg)
+		}
+	}
+	if len(result) == 0 {
+		return []string{"RS256"}
+	}
+	return result
+}
+
+// RandomString returns a random, URL-safe string for states, nonces and code verifiers
+func RandomString() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// CodeChallenge returns the S256 PKCE challenge of a code verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
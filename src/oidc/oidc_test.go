@@ -0,0 +1,391 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "chewawi"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost:8081/login/oidc/callback"
+)
+
+// mockProvider is a local OpenID Connect provider serving discovery, a JWKS and a
+// token endpoint that checks PKCE
+type mockProvider struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]*ecdsa.PrivateKey
+	signingKid  string
+	jwksFetches int
+	// grants are the issued authorization codes
+	grants map[string]mockGrant
+}
+
+// mockGrant is an authorization code waiting to be redeemed
+type mockGrant struct {
+	codeChallenge string
+	claims        jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	m := &mockProvider{keys: make(map[string]*ecdsa.PrivateKey), grants: make(map[string]mockGrant)}
+	m.rotateKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /jwks", m.jwks)
+	mux.HandleFunc("POST /token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// rotateKey adds a signing key and signs new tokens with it
+func (m *mockProvider) rotateKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[kid] = key
+	m.signingKid = kid
+}
+
+func (m *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeTestJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"ES256", "HS256"},
+	})
+}
+
+func (m *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jwksFetches++
+
+	keys := []map[string]string{}
+	for kid, key := range m.keys {
+		keys = append(keys, map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"use": "sig",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	writeTestJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != testClientID || secret != testClientSecret {
+		writeTestJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != testRedirectURL {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	// Codes can be redeemed once, with the verifier of the challenge they were issued for
+	m.mu.Lock()
+	grant, ok := m.grants[r.PostFormValue("code")]
+	delete(m.grants, r.PostFormValue("code"))
+	m.mu.Unlock()
+	if !ok || CodeChallenge(r.PostFormValue("code_verifier")) != grant.codeChallenge {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code verifier does not match"})
+		return
+	}
+
+	writeTestJSON(w, http.StatusOK, map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     m.idToken(grant.claims),
+	})
+}
+
+// claims returns the claims of a valid ID token for the nonce
+func (m *mockProvider) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                m.server.URL,
+		"aud":                testClientID,
+		"sub":                "user-1",
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              nonce,
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"name":               "Alice",
+		"preferred_username": "alice",
+		"groups":             []string{"staff", "writers"},
+	}
+}
+
+// idToken signs an ID token with the current signing key
+func (m *mockProvider) idToken(claims jwt.MapClaims) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = m.signingKid
+	signed, err := token.SignedString(m.keys[m.signingKid])
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+// authorize plays the browser going through the authorization endpoint and returns
+// the code the provider sends back for the claims
+func (m *mockProvider) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL has no S256 PKCE challenge: %s", authURL)
+	}
+	if query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL {
+		t.Fatalf("authorization URL has the wrong client: %s", authURL)
+	}
+
+	code := RandomString()
+	m.mu.Lock()
+	m.grants[code] = mockGrant{codeChallenge: query.Get("code_challenge"), claims: claims}
+	m.mu.Unlock()
+	return code
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newTestProvider discovers the mock provider
+func newTestProvider(t *testing.T, m *mockProvider) *Provider {
+	t.Helper()
+	p, err := NewProvider(context.Background(), Config{
+		IssuerURL:    m.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"profile", "email"},
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return p
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+
+	state, nonce, verifier := RandomString(), RandomString(), RandomString()
+	authURL := p.AuthCodeURL(state, nonce, verifier)
+	if !strings.HasPrefix(authURL, m.server.URL+"/authorize?") {
+		t.Fatalf("AuthCodeURL = %s", authURL)
+	}
+	if got := mustQuery(t, authURL).Get("state"); got != state {
+		t.Fatalf("state = %q, want %q", got, state)
+	}
+
+	code := m.authorize(t, authURL, m.claims(nonce))
+	claims, err := p.Exchange(context.Background(), code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Name != "Alice" || claims.PreferredUsername != "alice" {
+		t.Fatalf("claims = %+v", claims)
+	}
+	if groups := claims.Strings("groups"); len(groups) != 2 || groups[0] != "staff" || groups[1] != "writers" {
+		t.Fatalf("groups = %v", groups)
+	}
+
+	// The code was used up
+	if _, err := p.Exchange(context.Background(), code, verifier, nonce); err == nil {
+		t.Fatal("redeemed the same code twice")
+	}
+}
+
+func TestExchangeRejectsWrongCodeVerifier(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+
+	nonce, verifier := RandomString(), RandomString()
+	code := m.authorize(t, p.AuthCodeURL(RandomString(), nonce, verifier), m.claims(nonce))
+
+	_, err := p.Exchange(context.Background(), code, RandomString(), nonce)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Exchange with another verifier: err = %v, want invalid_grant", err)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// The example of RFC 7636, appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("CodeChallenge = %s, want %s", got, want)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+	const nonce = "nonce"
+
+	tests := []struct {
+		name    string
+		modify  func(claims jwt.MapClaims)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(jwt.MapClaims) {}},
+		{name: "nonce mismatch", modify: func(c jwt.MapClaims) { c["nonce"] = "other" }, wantErr: true},
+		{name: "missing nonce", modify: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: true},
+		{name: "other audience", modify: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, wantErr: true},
+		{name: "several audiences without azp", modify: func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "someone-else"} }, wantErr: true},
+		{name: "several audiences with other azp", modify: func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "someone-else"}
+			c["azp"] = "someone-else"
+		}, wantErr: true},
+		{name: "several audiences with our azp", modify: func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "someone-else"}
+			c["azp"] = testClientID
+		}},
+		{name: "other issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, wantErr: true},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * clockSkew).Unix() }, wantErr: true},
+		{name: "expired within clock skew", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-clockSkew / 2).Unix() }},
+		{name: "no expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: true},
+		{name: "issued in the future", modify: func(c jwt.MapClaims) { c["iat"] = time.Now().Add(2 * clockSkew).Unix() }, wantErr: true},
+		{name: "no subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := m.claims(nonce)
+			tt.modify(claims)
+
+			_, err := p.VerifyIDToken(context.Background(), m.idToken(claims), nonce)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyIDToken() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsForgedTokens(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+	claims := m.claims("nonce")
+
+	// Signed with a key the provider never published
+	forger := newMockProvider(t)
+	if _, err := p.VerifyIDToken(context.Background(), forger.idToken(claims), "nonce"); err == nil {
+		t.Fatal("accepted a token signed with an unknown key")
+	}
+
+	// Signed with a shared secret, which the provider's public keys can't verify
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = "key-1"
+	signed, err := hmac.SignedString([]byte(testClientSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyIDToken(context.Background(), signed, "nonce"); err == nil {
+		t.Fatal("accepted an HS256 token")
+	}
+}
+
+func TestVerifyIDTokenRefetchesKeysForUnknownKeyID(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+
+	verify := func() error {
+		_, err := p.VerifyIDToken(context.Background(), m.idToken(m.claims("nonce")), "nonce")
+		return err
+	}
+	fetches := func() int {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.jwksFetches
+	}
+
+	// The keys are fetched on first use and then cached
+	if err := verify(); err != nil {
+		t.Fatalf("first token: %v", err)
+	}
+	if err := verify(); err != nil {
+		t.Fatalf("second token: %v", err)
+	}
+	if got := fetches(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	// Right after a fetch, an unknown key ID doesn't hammer the provider
+	m.rotateKey(t, "key-2")
+	if err := verify(); err == nil {
+		t.Fatal("accepted a token with an unknown key ID before the refetch interval")
+	}
+	if got := fetches(); got != 1 {
+		t.Fatalf("JWKS fetched %d times within the refetch interval, want 1", got)
+	}
+
+	// Once the cache is older than the interval, the unknown key ID triggers a refetch
+	p.mu.Lock()
+	p.keysAt = time.Now().Add(-keysRefreshInterval - time.Second)
+	p.mu.Unlock()
+	if err := verify(); err != nil {
+		t.Fatalf("token signed with the rotated key: %v", err)
+	}
+	if got := fetches(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestNewProviderRejectsIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+
+	// A server handing out the mock provider's discovery document as its own
+	impostor := httptest.NewServer(http.HandlerFunc(m.discovery))
+	defer impostor.Close()
+
+	_, err := NewProvider(context.Background(), Config{IssuerURL: impostor.URL, ClientID: testClientID})
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("NewProvider() error = %v, want issuer mismatch", err)
+	}
+}
+
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	paragraphPattern = regexp.MustCompile(`(?s)<p>(.*?)</p>`)
+	tagPattern       = regexp.MustCompile(`<[^>]*>`)
+	imagePattern     = regexp.MustCompile(`<img[^>]*\ssrc="([^"]+)"`)
+)
+
+// Excerpt returns the text of the first paragraph of rendered HTML, cut at a word
+// boundary to at most maxLength characters
+func Excerpt(renderedHTML string, maxLength int) string {
+	for _, match := range paragraphPattern.FindAllStringSubmatch(renderedHTML, -1) {
+		// Skip paragraphs with nothing but an image or whitespace
+		text := html.UnescapeString(tagPattern.ReplaceAllString(match[1], ""))
+		if strings.TrimSpace(text) != "" {
+			return TruncateWords(text, maxLength)
+		}
+	}
+	return ""
+}
+
+// FirstImage returns the address of the first image in rendered HTML, or an empty string
+func FirstImage(renderedHTML string) string {
+	match := imagePattern.FindStringSubmatch(renderedHTML)
+	if match == nil {
+		return ""
+	}
+	return html.UnescapeString(match[1])
+}
+
+// TruncateWords joins text onto one line and cuts it to at most maxLength characters
+// without splitting a word, marking the cut with an ellipsis
+func TruncateWords(text string, maxLength int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+
+	// Leave room for the ellipsis, then back up to the last space
+	cut := string([]rune(text)[:maxLength-1])
+	if space := strings.LastIndex(cut, " "); space > 0 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// WordCount counts the words in the text of rendered HTML
+func WordCount(renderedHTML string) int {
+	return len(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(renderedHTML, " "))))
+}
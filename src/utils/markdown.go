@@ -1,25 +1,73 @@
 package utils
 
 import (
+	"fmt"
+	"net/url"
+	"runtime/debug"
+	"strings"
+
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
 )
 
+// markdownRevision is bumped whenever the HTML rendered from Markdown changes in a way
+// the options below and the gomarkdown version don't show
+const markdownRevision = 2
+
+// Markdown extensions and HTML flags posts are rendered with. Any author can write
+// posts, so raw HTML is dropped and links only keep trusted protocols, or a post
+// could run scripts as the admins reading it.
+const (
+	markdownExtensions = parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock
+	markdownHTMLFlags  = html.CommonFlags | html.HrefTargetBlank | html.SkipHTML | html.Safelink
+)
+
+// MarkdownVersion identifies the renderer behind MarkdownToHTML: its revision, its
+// options and the gomarkdown version. HTML stored with another version is stale.
+var MarkdownVersion = fmt.Sprintf("%d-%x-%x-%s", markdownRevision, markdownExtensions, markdownHTMLFlags, gomarkdownVersion())
+
+// gomarkdownVersion returns the version of gomarkdown built in, or "unknown" when
+// the binary carries no build information
+func gomarkdownVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/gomarkdown/markdown" {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
 // MarkdownToHTML converts Markdown content to HTML
 func MarkdownToHTML(md string) string {
 	// Create markdown parser with extensions
-	extensions := parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock
-	p := parser.NewWithExtensions(extensions)
+	p := parser.NewWithExtensions(markdownExtensions)
 	
 	// Parse markdown to AST
 	doc := p.Parse([]byte(md))
 	
 	// Create HTML renderer with extensions
-	htmlFlags := html.CommonFlags | html.HrefTargetBlank
-	opts := html.RendererOptions{Flags: htmlFlags}
+	opts := html.RendererOptions{Flags: markdownHTMLFlags}
 	renderer := html.NewRenderer(opts)
+	renderer.IsSafeURLOverride = isSafeLink
 	
 	// Render AST to HTML
 	return string(markdown.Render(doc, renderer))
-}
\ No newline at end of file
+}
+
+// safeLinkSchemes are the schemes links in posts may use, besides relative links
+var safeLinkSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// isSafeLink reports whether a link in a post may be kept. It replaces gomarkdown's
+// own check, which panics on empty links.
+func isSafeLink(link []byte) bool {
+	parsed, err := url.Parse(strings.TrimSpace(string(link)))
+	if err != nil {
+		return false
+	}
+	return parsed.Scheme == "" || safeLinkSchemes[strings.ToLower(parsed.Scheme)]
+}
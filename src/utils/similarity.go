@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// stopWords holds common English and Spanish words that carry no meaning for similarity
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true,
+	"you": true, "all": true, "any": true, "can": true, "had": true, "her": true,
+	"was": true, "one": true, "our": true, "out": true, "has": true, "his": true,
+	"how": true, "its": true, "may": true, "new": true, "now": true, "see": true,
+	"who": true, "did": true, "get": true, "use": true, "this": true, "that": true,
+	"with": true, "have": true, "from": true, "they": true, "will": true, "would": true,
+	"there": true, "their": true, "what": true, "about": true, "which": true, "when": true,
+	"your": true, "just": true, "into": true, "than": true, "then": true, "them": true,
+	"some": true, "also": true, "been": true, "were": true, "like": true, "more": true,
+	"los": true, "las": true, "del": true, "que": true, "por": true, "con": true,
+	"una": true, "para": true, "como": true, "pero": true, "más": true, "esta": true,
+	"este": true, "sus": true, "les": true, "fue": true, "son": true, "muy": true,
+}
+
+// Tokenize splits text into lowercase words, dropping short words and stop words
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := words[:0]
+	for _, word := range words {
+		if len([]rune(word)) < 3 || stopWords[word] {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// TFIDFVectors builds a TF-IDF weighted term vector for each document
+func TFIDFVectors(documents []string) []map[string]float64 {
+	// Count term frequencies per document and document frequencies overall
+	termCounts := make([]map[string]int, len(documents))
+	docFrequency := make(map[string]int)
+	for i, document := range documents {
+		counts := make(map[string]int)
+		for _, token := range Tokenize(document) {
+			counts[token]++
+		}
+		for term := range counts {
+			docFrequency[term]++
+		}
+		termCounts[i] = counts
+	}
+
+	// Weight each term by how rare it is across the corpus
+	total := float64(len(documents))
+	vectors := make([]map[string]float64, len(documents))
+	for i, counts := range termCounts {
+		vector := make(map[string]float64, len(counts))
+		for term, count := range counts {
+			idf := math.Log(1 + total/float64(docFrequency[term]))
+			vector[term] = (1 + math.Log(float64(count))) * idf
+		}
+		vectors[i] = vector
+	}
+
+	return vectors
+}
+
+// CosineSimilarity returns the cosine of the angle between two term vectors
+func CosineSimilarity(a, b map[string]float64) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	var dot, normA, normB float64
+	for term, weight := range a {
+		dot += weight * b[term]
+		normA += weight * weight
+	}
+	for _, weight := range b {
+		normB += weight * weight
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,253 @@
+// Package views holds the site's templates and renders them.
+//
+// Templates are grouped by directory:
+//
+//	layouts/   page skeletons, each executed by its file name without .html
+//	partials/  blocks such as "hero" or "footer", available to every layout and page
+//	mail/      plain text emails, executed by the name they define
+//
+// Every other .html file is a page, named by its path without .html such as
+// "admin/login". A page defines a "content" block that its layout places.
+//
+// Templates can use the translation and date functions of package i18n.
+package views
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"chewawi_web/src/i18n"
+)
+
+// FS holds the templates, built into the binary so it runs from any directory
+//
+//go:embed */*.html mail/*.txt
+var FS embed.FS
+
+// DefaultLayout is the layout pages are rendered in unless another one is named
+const DefaultLayout = "base"
+
+// Renderer renders pages inside layouts, parsing the templates once up front
+type Renderer struct {
+	fsys fs.FS
+	// reload re-parses the templates whenever a file changed, for development
+	reload bool
+
+	mu sync.RWMutex
+	// pages holds a template set per layout and page
+	pages map[string]map[string]*template.Template
+	mails *texttemplate.Template
+	// modTime is the latest modification time of the parsed files
+	modTime time.Time
+}
+
+// NewRenderer parses the templates in fsys. With reload set, they're parsed again
+// when a file changes, which only makes sense for a directory on disk.
+func NewRenderer(fsys fs.FS, reload bool) (*Renderer, error) {
+	r := &Renderer{fsys: fsys, reload: reload}
+	if err := r.parse(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Render renders a page inside the named layout
+func (r *Renderer) Render(w io.Writer, layout, page string, data any) error {
+	if err := r.reloadIfChanged(); err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	tmpl := r.pages[layout][page]
+	r.mu.RUnlock()
+	if tmpl == nil {
+		return fmt.Errorf("views: no page %q in layout %q", page, layout)
+	}
+
+	return tmpl.ExecuteTemplate(w, layout+".html", data)
+}
+
+// Mail renders a plain text email template
+func (r *Renderer) Mail(w io.Writer, name string, data any) error {
+	if err := r.reloadIfChanged(); err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	mails := r.mails
+	r.mu.RUnlock()
+
+	return mails.ExecuteTemplate(w, name, data)
+}
+
+// parse parses every layout, partial, page and email
+func (r *Renderer) parse() error {
+	layouts, err := fs.Glob(r.fsys, "layouts/*.html")
+	if err != nil {
+		return err
+	}
+	if len(layouts) == 0 {
+		return errors.New("views: no layouts found")
+	}
+	partials, err := fs.Glob(r.fsys, "partials/*.html")
+	if err != nil {
+		return err
+	}
+
+	// Note when the files last changed before reading them
+	modTime, err := latestModTime(r.fsys)
+	if err != nil {
+		return err
+	}
+
+	// Every other HTML file is a page
+	var pageFiles []string
+	err = fs.WalkDir(r.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if path.Ext(name) == ".html" && !strings.HasPrefix(name, "layouts/") && !strings.HasPrefix(name, "partials/") {
+			pageFiles = append(pageFiles, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Partials are shared by everything
+	base := template.New("").Funcs(i18n.Funcs())
+	if len(partials) > 0 {
+		if base, err = base.ParseFS(r.fsys, partials...); err != nil {
+			return fmt.Errorf("views: %w", err)
+		}
+	}
+
+	// Then each page gets its own set per layout, so their "content" blocks don't clash
+	pages := make(map[string]map[string]*template.Template)
+	for _, layoutFile := range layouts {
+		layoutSet, err := template.Must(base.Clone()).ParseFS(r.fsys, layoutFile)
+		if err != nil {
+			return fmt.Errorf("views: %w", err)
+		}
+
+		layout := strings.TrimSuffix(path.Base(layoutFile), ".html")
+		pages[layout] = make(map[string]*template.Template)
+		for _, pageFile := range pageFiles {
+			pageSet, err := template.Must(layoutSet.Clone()).ParseFS(r.fsys, pageFile)
+			if err != nil {
+				return fmt.Errorf("views: %w", err)
+			}
+			if pageSet.Lookup("content") == nil {
+				return fmt.Errorf("views: page %s does not define a content block", pageFile)
+			}
+			pages[layout][strings.TrimSuffix(pageFile, ".html")] = pageSet
+		}
+	}
+
+	mails, err := texttemplate.New("").Funcs(i18n.Funcs()).ParseFS(r.fsys, "mail/*.txt")
+	if err != nil {
+		return fmt.Errorf("views: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pages = pages
+	r.mails = mails
+	r.modTime = modTime
+	return nil
+}
+
+// reloadIfChanged parses the templates again if reloading is on and a file changed
+func (r *Renderer) reloadIfChanged() error {
+	if !r.reload {
+		return nil
+	}
+
+	modTime, err := latestModTime(r.fsys)
+	if err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	changed := modTime.After(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	log.Println("Templates changed, parsing them again")
+	return r.parse()
+}
+
+// latestModTime returns when the most recently changed file in fsys was modified
+func latestModTime(fsys fs.FS) (time.Time, error) {
+	var modTime time.Time
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+		return nil
+	})
+	return modTime, err
+}
+
+// defaultRenderer is the renderer used by the package functions, see Load
+var defaultRenderer *Renderer
+
+// Load parses the templates for the package functions. They're built into the
+// binary, unless TEMPLATE_DEV is true: then they're read from TEMPLATE_DIR and
+// parsed again when they change, so edits show up without a rebuild.
+func Load() error {
+	fsys, reload := fs.FS(FS), false
+	if os.Getenv("TEMPLATE_DEV") == "true" {
+		dir := os.Getenv("TEMPLATE_DIR")
+		if dir == "" {
+			dir = "src/views"
+		}
+		fsys, reload = os.DirFS(dir), true
+		log.Printf("Reading templates from %s and reloading them on change", dir)
+	}
+
+	renderer, err := NewRenderer(fsys, reload)
+	if err != nil {
+		return err
+	}
+	defaultRenderer = renderer
+	return nil
+}
+
+// Render renders a page inside the named layout with the templates set up by Load
+func Render(w io.Writer, layout, page string, data any) error {
+	if defaultRenderer == nil {
+		return errors.New("views: templates are not loaded")
+	}
+	return defaultRenderer.Render(w, layout, page, data)
+}
+
+// Page renders a page inside the default layout
+func Page(w io.Writer, page string, data any) error {
+	return Render(w, DefaultLayout, page, data)
+}
+
+// Mail renders a plain text email with the templates set up by Load
+func Mail(w io.Writer, name string, data any) error {
+	if defaultRenderer == nil {
+		return errors.New("views: templates are not loaded")
+	}
+	return defaultRenderer.Mail(w, name, data)
+}
@@ -0,0 +1,81 @@
+// WebAuthn helpers for passkey registration and sign-in.
+// The server sends and expects binary fields as base64url strings.
+
+function base64urlToBuffer(value) {
+    const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    const padded = base64 + "=".repeat((4 - (base64.length % 4)) % 4);
+    return Uint8Array.from(atob(padded), (c) => c.charCodeAt(0)).buffer;
+}
+
+function bufferToBase64url(buffer) {
+    const bytes = String.fromCharCode(...new Uint8Array(buffer));
+    return btoa(bytes).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function csrfToken() {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.content : "";
+}
+
+async function postJSON(url, body) {
+    const response = await fetch(url, {
+        method: "POST",
+        headers: {"Content-Type": "application/json", "X-CSRF-Token": csrfToken()},
+        body: body ? JSON.stringify(body) : undefined,
+        credentials: "same-origin",
+    });
+    const data = await response.json();
+    if (!response.ok) {
+        throw new Error(data.error || "Request failed");
+    }
+    return data;
+}
+
+async function registerPasskey(name) {
+    const options = await postJSON("/owner/passkeys/register/begin");
+    const publicKey = options.publicKey;
+    publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+    publicKey.user.id = base64urlToBuffer(publicKey.user.id);
+    (publicKey.excludeCredentials || []).forEach((c) => (c.id = base64urlToBuffer(c.id)));
+
+    const credential = await navigator.credentials.create({publicKey});
+    const result = await postJSON("/owner/passkeys/register/finish?name=" + encodeURIComponent(name), {
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+            attestationObject: bufferToBase64url(credential.response.attestationObject),
+            transports: credential.response.getTransports ? credential.response.getTransports() : [],
+        },
+    });
+    window.location = result.redirect;
+}
+
+async function signInWithPasskey(remember, next) {
+    const options = await postJSON("/login/passkey/begin");
+    const publicKey = options.publicKey;
+    publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+    (publicKey.allowCredentials || []).forEach((c) => (c.id = base64urlToBuffer(c.id)));
+
+    const credential = await navigator.credentials.get({publicKey});
+    const params = new URLSearchParams();
+    if (remember) {
+        params.set("remember", "1");
+    }
+    if (next) {
+        params.set("next", next);
+    }
+    const result = await postJSON("/login/passkey/finish?" + params, {
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+            authenticatorData: bufferToBase64url(credential.response.authenticatorData),
+            signature: bufferToBase64url(credential.response.signature),
+            userHandle: credential.response.userHandle ? bufferToBase64url(credential.response.userHandle) : null,
+        },
+    });
+    window.location = result.redirect;
+}